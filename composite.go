@@ -0,0 +1,195 @@
+package otgorm
+
+import (
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// CompositeTracer returns tracer starting every span on all given tracers, pass it to WithTracer
+// to report spans to several systems at once. Injecting writes the context of every tracer into the carrier,
+// extracting keeps contexts of the tracers which succeeded
+func CompositeTracer(tracers ...opentracing.Tracer) opentracing.Tracer {
+	return &compositeTracer{tracers: tracers}
+}
+
+type compositeTracer struct {
+	tracers []opentracing.Tracer
+}
+
+type compositeSpan struct {
+	tracer *compositeTracer
+	spans  []opentracing.Span
+}
+
+// compositeSpanContext keeps span contexts by the index of their tracer, nil for tracers without one
+type compositeSpanContext struct {
+	contexts []opentracing.SpanContext
+}
+
+func (t *compositeTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	sso := opentracing.StartSpanOptions{}
+	for _, opt := range opts {
+		opt.Apply(&sso)
+	}
+	sp := &compositeSpan{tracer: t, spans: make([]opentracing.Span, len(t.tracers))}
+	for i, tr := range t.tracers {
+		sp.spans[i] = tr.StartSpan(operationName, t.spanOptions(i, sso)...)
+	}
+	return sp
+}
+
+// spanOptions returns options of sso for the tracer at index i, composite references are replaced by its context
+func (t *compositeTracer) spanOptions(i int, sso opentracing.StartSpanOptions) []opentracing.StartSpanOption {
+	var opts []opentracing.StartSpanOption
+	for _, ref := range sso.References {
+		if cc, ok := ref.ReferencedContext.(*compositeSpanContext); ok {
+			if i >= len(cc.contexts) || cc.contexts[i] == nil {
+				continue
+			}
+			ref.ReferencedContext = cc.contexts[i]
+		}
+		opts = append(opts, ref)
+	}
+	if !sso.StartTime.IsZero() {
+		opts = append(opts, opentracing.StartTime(sso.StartTime))
+	}
+	if sso.Tags != nil {
+		opts = append(opts, opentracing.Tags(sso.Tags))
+	}
+	return opts
+}
+
+func (t *compositeTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	cc, ok := sc.(*compositeSpanContext)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+	for i, tr := range t.tracers {
+		if i >= len(cc.contexts) || cc.contexts[i] == nil {
+			continue
+		}
+		if err := tr.Inject(cc.contexts[i], format, carrier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *compositeTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	cc := &compositeSpanContext{contexts: make([]opentracing.SpanContext, len(t.tracers))}
+	var firstErr error
+	found := false
+	for i, tr := range t.tracers {
+		sc, err := tr.Extract(format, carrier)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		cc.contexts[i] = sc
+		found = true
+	}
+	if !found {
+		if firstErr == nil {
+			firstErr = opentracing.ErrSpanContextNotFound
+		}
+		return nil, firstErr
+	}
+	return cc, nil
+}
+
+// ForeachBaggageItem iterates baggage of the first available context, all of them carry the same items
+func (c *compositeSpanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for _, sc := range c.contexts {
+		if sc != nil {
+			sc.ForeachBaggageItem(handler)
+			return
+		}
+	}
+}
+
+func (s *compositeSpan) Finish() {
+	for _, sp := range s.spans {
+		sp.Finish()
+	}
+}
+
+func (s *compositeSpan) FinishWithOptions(opts opentracing.FinishOptions) {
+	for _, sp := range s.spans {
+		sp.FinishWithOptions(opts)
+	}
+}
+
+func (s *compositeSpan) Context() opentracing.SpanContext {
+	cc := &compositeSpanContext{contexts: make([]opentracing.SpanContext, len(s.spans))}
+	for i, sp := range s.spans {
+		cc.contexts[i] = sp.Context()
+	}
+	return cc
+}
+
+func (s *compositeSpan) SetOperationName(operationName string) opentracing.Span {
+	for _, sp := range s.spans {
+		sp.SetOperationName(operationName)
+	}
+	return s
+}
+
+func (s *compositeSpan) SetTag(key string, value interface{}) opentracing.Span {
+	for _, sp := range s.spans {
+		sp.SetTag(key, value)
+	}
+	return s
+}
+
+func (s *compositeSpan) LogFields(fields ...log.Field) {
+	for _, sp := range s.spans {
+		sp.LogFields(fields...)
+	}
+}
+
+func (s *compositeSpan) LogKV(alternatingKeyValues ...interface{}) {
+	for _, sp := range s.spans {
+		sp.LogKV(alternatingKeyValues...)
+	}
+}
+
+func (s *compositeSpan) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	for _, sp := range s.spans {
+		sp.SetBaggageItem(restrictedKey, value)
+	}
+	return s
+}
+
+// BaggageItem returns the item of the first span having it
+func (s *compositeSpan) BaggageItem(restrictedKey string) string {
+	for _, sp := range s.spans {
+		if v := sp.BaggageItem(restrictedKey); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *compositeSpan) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *compositeSpan) LogEvent(event string) {
+	for _, sp := range s.spans {
+		sp.LogEvent(event)
+	}
+}
+
+func (s *compositeSpan) LogEventWithPayload(event string, payload interface{}) {
+	for _, sp := range s.spans {
+		sp.LogEventWithPayload(event, payload)
+	}
+}
+
+func (s *compositeSpan) Log(data opentracing.LogData) {
+	for _, sp := range s.spans {
+		sp.Log(data)
+	}
+}
@@ -0,0 +1,66 @@
+package otgorm
+
+import (
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestCompositeTracerReferences(t *testing.T) {
+	a, b := mocktracer.New(), mocktracer.New()
+	tr := CompositeTracer(a, b)
+	parent := tr.StartSpan("parent")
+	child := tr.StartSpan("child", opentracing.ChildOf(parent.Context()), opentracing.Tag{Key: "k", Value: "v"})
+	child.SetTag("db.table", "users")
+	child.Finish()
+	parent.Finish()
+	for name, mt := range map[string]*mocktracer.MockTracer{"a": a, "b": b} {
+		spans := mt.FinishedSpans()
+		if len(spans) != 2 {
+			t.Fatalf("%s: got %d spans, want 2", name, len(spans))
+		}
+		c, p := spans[0], spans[1]
+		if c.ParentID != p.SpanContext.SpanID || c.SpanContext.TraceID != p.SpanContext.TraceID {
+			t.Errorf("%s: child isn't a child of the parent span of the same tracer", name)
+		}
+		if c.Tag("k") != "v" || c.Tag("db.table") != "users" {
+			t.Errorf("%s: child tags = %v", name, c.Tags())
+		}
+	}
+}
+
+func TestCompositeTracerInjectExtract(t *testing.T) {
+	mt := mocktracer.New()
+	tr := CompositeTracer(mt, opentracing.NoopTracer{})
+	sp := tr.StartSpan("parent")
+	carrier := opentracing.TextMapCarrier{}
+	if err := tr.Inject(sp.Context(), opentracing.TextMap, carrier); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := tr.Extract(opentracing.TextMap, carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := sc.(*compositeSpanContext)
+	if cc.contexts[1] != nil {
+		t.Errorf("noop tracer context = %v, want none", cc.contexts[1])
+	}
+	child := tr.StartSpan("child", opentracing.ChildOf(sc))
+	child.Finish()
+	sp.Finish()
+	spans := mt.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].ParentID != spans[1].SpanContext.SpanID {
+		t.Errorf("child parent id = %d, want %d", spans[0].ParentID, spans[1].SpanContext.SpanID)
+	}
+
+	if _, err := tr.Extract(opentracing.TextMap, opentracing.TextMapCarrier{}); err == nil {
+		t.Error("extracting from an empty carrier succeeded")
+	}
+	if err := tr.Inject(mt.StartSpan("foreign").Context(), opentracing.TextMap, carrier); err != opentracing.ErrInvalidSpanContext {
+		t.Errorf("injecting a foreign context: got %v, want ErrInvalidSpanContext", err)
+	}
+}
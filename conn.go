@@ -0,0 +1,53 @@
+package otgorm
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+const maxCachedConnectionIDs = 1024
+
+// connectionIDQueries maps dialect names to queries returning id of the server connection
+var connectionIDQueries = map[string]string{
+	"mysql":    "SELECT CONNECTION_ID()",
+	"postgres": "SELECT pg_backend_pid()",
+}
+
+// connectionIDs caches server connection ids of transactions, a transaction keeps its connection
+// so the id is fetched once per transaction
+type connectionIDs struct {
+	mu  sync.Mutex
+	ids map[*sql.Tx]int64
+}
+
+// get returns id of the connection the scope's transaction runs on, false when the scope is outside of
+// a transaction, the dialect is not supported or fetching the id failed
+func (c *connectionIDs) get(scope *gorm.Scope) (int64, bool) {
+	tx, ok := scope.SQLDB().(*sql.Tx)
+	if !ok {
+		return 0, false
+	}
+	query, ok := connectionIDQueries[scope.Dialect().GetName()]
+	if !ok {
+		return 0, false
+	}
+	c.mu.Lock()
+	id, ok := c.ids[tx]
+	c.mu.Unlock()
+	if ok {
+		return id, true
+	}
+	if err := tx.QueryRow(query).Scan(&id); err != nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// finished transactions are never removed one by one, drop them all at once instead
+	if c.ids == nil || len(c.ids) >= maxCachedConnectionIDs {
+		c.ids = map[*sql.Tx]int64{}
+	}
+	c.ids[tx] = id
+	return id, true
+}
@@ -0,0 +1,15 @@
+// Package otgorm traces jinzhu/gorm statements with opentracing.
+//
+// Install the callbacks once and attach the span of every request context to the DB before querying:
+//
+//	otgorm.AddGormCallbacks(db)
+//	...
+//	otgorm.SetSpanToGorm(ctx, db).Find(&users)
+//
+// The statement spans are children of the span found in ctx. gorm v1 has no db.WithContext and does not
+// pass a context to callbacks, so the span can't be picked up automatically, SetSpanToGorm is the only way
+// to hand it over. Use WithRootSpans to trace statements run without it as well.
+//
+// Every statement gets its own span. gorm v1 has no batch inserts like CreateInBatches, creating several records
+// runs one INSERT per record, to group them start a span for the whole operation and pass its context to SetSpanToGorm.
+package otgorm
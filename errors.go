@@ -0,0 +1,149 @@
+package otgorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const maxErrorStackLines = 32
+
+// DefaultErrorFilter is the error filter used unless WithErrorFilter is set, every error except
+// context.Canceled marks the span as failed as a canceled context usually means the client went away
+func DefaultErrorFilter(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+// errorKind returns db.error.kind tag value of err, empty if err is not classified
+func errorKind(err error) string {
+	switch {
+	case isDeadlock(err):
+		return "deadlock"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	}
+	return ""
+}
+
+// isDeadlock reports whether err is a MySQL (1213) or Postgres (40P01) deadlock error
+func isDeadlock(err error) bool {
+	if n, ok := errorNumber(err); ok && n == 1213 {
+		return true
+	}
+	return sqlState(err) == "40P01"
+}
+
+// sqlState returns SQLSTATE code of driver errors, read from SQLState method like the *pq.Error and
+// *pgconn.PgError ones, SQLState field of *mysql.MySQLError or the Code field of older *pq.Error
+func sqlState(err error) string {
+	state := ""
+	walkErrors(err, func(err error) bool {
+		if e, ok := err.(interface{ SQLState() string }); ok {
+			state = e.SQLState()
+			return false
+		}
+		return true
+	})
+	if state != "" {
+		return state
+	}
+	if v, ok := driverErrorField(err, "SQLState"); ok && v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, v.Len())
+		for i := range b {
+			b[i] = byte(v.Index(i).Uint())
+		}
+		if b[0] != 0 {
+			return string(b)
+		}
+	}
+	if v, ok := driverErrorField(err, "Code"); ok && v.Kind() == reflect.String && len(v.String()) == 5 {
+		return v.String()
+	}
+	return ""
+}
+
+// errorCode returns numeric driver specific code of err, the Number field of *mysql.MySQLError and mssql.Error
+// or the Code field of sqlite3.Error. String codes like the ones of *pq.Error and *pgconn.PgError are SQLSTATE
+// values reported by sqlState, they are not returned
+func errorCode(err error) (int64, bool) {
+	if n, ok := errorNumber(err); ok {
+		return n, true
+	}
+	v, ok := driverErrorField(err, "Code")
+	if !ok {
+		return 0, false
+	}
+	return intValue(v)
+}
+
+// errorNumber returns numeric error code of driver errors like *mysql.MySQLError having a Number field
+func errorNumber(err error) (int64, bool) {
+	v, ok := driverErrorField(err, "Number")
+	if !ok {
+		return 0, false
+	}
+	return intValue(v)
+}
+
+// intValue returns value of v when it is of an integer kind
+func intValue(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// driverErrorField returns field name of the first struct error in err chain having it, it reads
+// driver errors like *mysql.MySQLError or *pq.Error without importing the drivers
+func driverErrorField(err error, name string) (reflect.Value, bool) {
+	var field reflect.Value
+	walkErrors(err, func(err error) bool {
+		v := reflect.ValueOf(err)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			field = v.FieldByName(name)
+		}
+		return !field.IsValid()
+	})
+	return field, field.IsValid()
+}
+
+// walkErrors calls fn for err and errors it wraps until fn returns false, gorm.Errors is walked
+// starting from its first error which is usually the driver one
+func walkErrors(err error, fn func(error) bool) {
+	if errs, ok := err.(gorm.Errors); ok && len(errs) > 0 {
+		err = errs[0]
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if !fn(err) {
+			return
+		}
+	}
+}
+
+// errorStack returns stack of the first error in err chain having a StackTrace method,
+// like the ones of github.com/pkg/errors, trimmed to maxErrorStackLines
+func errorStack(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if _, ok := reflect.TypeOf(err).MethodByName("StackTrace"); !ok {
+			continue
+		}
+		lines := strings.Split(fmt.Sprintf("%+v", err), "\n")
+		if len(lines) > maxErrorStackLines {
+			lines = lines[:maxErrorStackLines]
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package otgorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err    error
+		kind   string
+		failed bool
+	}{
+		{context.Canceled, "canceled", false},
+		{fmt.Errorf("query: %w", context.Canceled), "canceled", false},
+		{context.DeadlineExceeded, "deadline_exceeded", true},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), "deadline_exceeded", true},
+		{errors.New("syntax error"), "", true},
+	}
+	for _, test := range tests {
+		if got := errorKind(test.err); got != test.kind {
+			t.Errorf("errorKind(%v) = %q, want %q", test.err, got, test.kind)
+		}
+		if got := DefaultErrorFilter(test.err); got != test.failed {
+			t.Errorf("DefaultErrorFilter(%v) = %v, want %v", test.err, got, test.failed)
+		}
+	}
+}
+
+// failQueries makes queries of db fail with err once their SQL is built
+func failQueries(db *gorm.DB, err error) {
+	db.Callback().Query().After("gorm:query").Before("tracing:query_after").Register("test:fail", func(scope *gorm.Scope) {
+		scope.Err(err)
+	})
+}
+
+func TestCanceledAndDeadlineExceededQueries(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		opts   []Option
+		kind   string
+		failed bool
+	}{
+		{"Canceled", context.Canceled, nil, "canceled", false},
+		{"DeadlineExceeded", context.DeadlineExceeded, nil, "deadline_exceeded", true},
+		{"CanceledWithFilter", context.Canceled, []Option{WithErrorFilter(func(error) bool { return true })}, "canceled", true},
+		{"DeadlineExceededWithFilter", context.DeadlineExceeded, []Option{WithErrorFilter(func(err error) bool {
+			return !errors.Is(err, context.DeadlineExceeded)
+		})}, "deadline_exceeded", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			db := newTestDB(t, test.opts...)
+			failQueries(db, test.err)
+			var users []testUser
+			if err := SetSpanToGorm(newTestContext(tr), db).Find(&users).Error; !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			spans := finishedStatementSpans(tr)
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if got := spans[0].Tag("db.error.kind"); got != test.kind {
+				t.Errorf("db.error.kind = %v, want %v", got, test.kind)
+			}
+			if got := spans[0].Tag(string(ext.Error)); got != test.failed {
+				t.Errorf("error = %v, want %v", got, test.failed)
+			}
+		})
+	}
+}
+
+type testMySQLError struct {
+	Number   uint16
+	SQLState [5]byte
+	Message  string
+}
+
+func (e *testMySQLError) Error() string { return e.Message }
+
+type testPostgresError struct {
+	Code    string
+	Message string
+}
+
+func (e *testPostgresError) Error() string { return e.Message }
+
+type testSQLiteError struct {
+	Code int
+}
+
+func (e testSQLiteError) Error() string { return "sqlite error" }
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		code  int64
+		ok    bool
+		state string
+	}{
+		{"MySQL", &testMySQLError{Number: 1062, SQLState: [5]byte{'2', '3', '0', '0', '0'}}, 1062, true, "23000"},
+		{"Postgres", &testPostgresError{Code: "23505"}, 0, false, "23505"},
+		{"SQLite", fmt.Errorf("insert: %w", testSQLiteError{Code: 19}), 19, true, ""},
+		{"Plain", errors.New("boom"), 0, false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, ok := errorCode(test.err)
+			if code != test.code || ok != test.ok {
+				t.Errorf("errorCode = %v, %v, want %v, %v", code, ok, test.code, test.ok)
+			}
+			if got := sqlState(test.err); got != test.state {
+				t.Errorf("sqlState = %q, want %q", got, test.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const explainTimeout = time.Second
+
+// explainPrefixes maps dialect names to statements returning the query plan without executing the query
+var explainPrefixes = map[string]string{
+	"mysql":    "EXPLAIN ",
+	"postgres": "EXPLAIN ",
+	"sqlite3":  "EXPLAIN QUERY PLAN ",
+}
+
+// explain logs the plan of the scope's statement to sp, statements of unsupported dialects,
+// multi-statement SQL and statements run inside a transaction are skipped
+func explain(scope *gorm.Scope, sp opentracing.Span) {
+	prefix, ok := explainPrefixes[scope.Dialect().GetName()]
+	if !ok || len(splitStatements(scope.SQL)) > 1 {
+		return
+	}
+	db, ok := scope.SQLDB().(*sql.DB)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+	plan, err := queryPlan(ctx, db, prefix+scope.SQL, scope.SQLVars...)
+	if err != nil {
+		sp.LogFields(log.String("event", "explain"), log.Error(err))
+		return
+	}
+	sp.LogFields(log.String("event", "explain"), log.String("db.plan", plan))
+}
+
+// queryPlan runs query and returns its rows one per line with columns separated by " | "
+func queryPlan(ctx context.Context, db *sql.DB, query string, args ...interface{}) (string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(sql.RawBytes)
+	}
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			return "", err
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = fmt.Sprintf("%s", *v.(*sql.RawBytes))
+		}
+		lines = append(lines, strings.Join(cells, " | "))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
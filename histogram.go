@@ -0,0 +1,104 @@
+package otgorm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultHistogramBounds are the bucket upper bounds used by NewHistogram when none are given
+var DefaultHistogramBounds = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram is a lightweight in-memory histogram of statement durations by operation,
+// feed it with WithMetricsHook(h.Observe) and read it with Snapshot
+type Histogram struct {
+	bounds []time.Duration
+
+	mu         sync.Mutex
+	operations map[string]*OperationHistogram
+}
+
+// OperationHistogram holds durations of statements of one operation
+type OperationHistogram struct {
+	// Counts are numbers of statements per bucket, the last one counts statements slower than every bound
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// HistogramSnapshot is a copy of histogram data
+type HistogramSnapshot struct {
+	Bounds     []time.Duration
+	Operations map[string]OperationHistogram
+}
+
+// NewHistogram returns histogram with buckets of given upper bounds, DefaultHistogramBounds by default
+func NewHistogram(bounds ...time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultHistogramBounds
+	}
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &Histogram{
+		bounds:     bounds,
+		operations: map[string]*OperationHistogram{},
+	}
+}
+
+// Observe records duration of the statement
+func (h *Histogram) Observe(m QueryMetrics) {
+	bucket := sort.Search(len(h.bounds), func(i int) bool { return m.Duration <= h.bounds[i] })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	op, ok := h.operations[m.Operation]
+	if !ok {
+		op = &OperationHistogram{Counts: make([]uint64, len(h.bounds)+1)}
+		h.operations[m.Operation] = op
+	}
+	op.Counts[bucket]++
+	op.Count++
+	op.Sum += m.Duration
+}
+
+// Snapshot returns copy of the current histogram data
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{
+		Bounds:     append([]time.Duration(nil), h.bounds...),
+		Operations: make(map[string]OperationHistogram, len(h.operations)),
+	}
+	for name, op := range h.operations {
+		s.Operations[name] = OperationHistogram{
+			Counts: append([]uint64(nil), op.Counts...),
+			Count:  op.Count,
+			Sum:    op.Sum,
+		}
+	}
+	return s
+}
+
+// Quantile returns upper bound of the bucket holding the q quantile of durations, from 0 to 1,
+// the largest bound when it falls into the last bucket
+func (s HistogramSnapshot) Quantile(operation string, q float64) time.Duration {
+	op, ok := s.Operations[operation]
+	if !ok || op.Count == 0 || len(s.Bounds) == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(op.Count))
+	var seen uint64
+	for i, n := range op.Counts {
+		seen += n
+		if seen > rank || seen == op.Count {
+			if i < len(s.Bounds) {
+				return s.Bounds[i]
+			}
+			break
+		}
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
@@ -0,0 +1,95 @@
+package otgorm
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// primaryKey returns primary key value of the single record the scope works with, composite keys are
+// formatted as col=value pairs separated by commas. Empty if the scope's value isn't a struct or its key is blank
+func primaryKey(scope *gorm.Scope) string {
+	if scope.Value == nil || scope.IndirectValue().Kind() != reflect.Struct {
+		return ""
+	}
+	fields := scope.PrimaryFields()
+	if len(fields) == 1 {
+		if fields[0].IsBlank {
+			return ""
+		}
+		return fmt.Sprint(fields[0].Field.Interface())
+	}
+	var pairs []string
+	for _, field := range fields {
+		if field.IsBlank {
+			return ""
+		}
+		pairs = append(pairs, fmt.Sprintf("%v=%v", field.DBName, field.Field.Interface()))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// affectedKeys returns up to max primary key values the scope's statement filters on with pk = ? or pk IN (?, ...)
+// after its WHERE, values are taken from bind variables, inlined ones are not reported. The second result tells
+// whether more keys were found than returned
+func affectedKeys(scope *gorm.Scope, max int) ([]string, bool) {
+	pk := scope.PrimaryKey()
+	if pk == "" {
+		return nil, false
+	}
+	sql := scope.SQL
+	where := -1
+	scanWords(sql, func(word string, pos, _ int) bool {
+		if strings.EqualFold(word, "WHERE") {
+			where = pos
+			return false
+		}
+		return true
+	})
+	if where < 0 {
+		return nil, false
+	}
+	dialect := scope.Dialect().GetName()
+	phs := placeholders(sql, dialect)
+	re := regexp.MustCompile("(?i)(?:^|[^\\w])[`\"\\[]?" + regexp.QuoteMeta(pk) + "[`\"\\]]?\\s*(=|IN\\s*\\()")
+	var keys []string
+	more := false
+	for _, m := range re.FindAllStringSubmatchIndex(sql[where:], -1) {
+		start, end := where+m[1], len(sql)
+		if sql[where+m[2]] == '=' {
+			start += len(sql[start:]) - len(strings.TrimLeft(sql[start:], " \t\n"))
+			end = start + 1
+		} else if idx := strings.IndexByte(sql[start:], ')'); idx >= 0 {
+			end = start + idx
+		}
+		for i, ph := range phs {
+			if ph[0] < start || ph[0] >= end {
+				continue
+			}
+			if idx, ok := varIndex(sql[ph[0]:ph[1]], i); ok && idx < len(scope.SQLVars) {
+				if len(keys) == max {
+					more = true
+					break
+				}
+				keys = append(keys, fmt.Sprint(scope.SQLVars[idx]))
+			}
+		}
+	}
+	return keys, more
+}
+
+// varIndex returns index of the bind variable referenced by placeholder, the i-th one of the statement
+func varIndex(placeholder string, i int) (int, bool) {
+	if placeholder == "?" {
+		return i, true
+	}
+	n, err := strconv.Atoi(strings.TrimLeft(placeholder, "$@pP"))
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n - 1, true
+}
@@ -0,0 +1,56 @@
+package otgorm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestAffectedKeys(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithAffectedKeys(2))
+	ctx := newTestContext(tr)
+	wide := strings.Repeat("ɐ", 30)
+	if err := db.Table(wide).CreateTable(&testUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		run  func() error
+		keys interface{}
+	}{
+		{"Equal", func() error {
+			return SetSpanToGorm(ctx, db).Where("id = ?", 7).Delete(&testUser{}).Error
+		}, "7"},
+		{"In", func() error {
+			return SetSpanToGorm(ctx, db).Where("id IN (?)", []int{1, 2, 3}).Delete(&testUser{}).Error
+		}, "1,2,..."},
+		{"NonASCIITable", func() error {
+			return SetSpanToGorm(ctx, db).Table(wide).Where("id = ?", 1).Delete(&testUser{}).Error
+		}, "1"},
+		{"WhereInString", func() error {
+			return SetSpanToGorm(ctx, db).Where("name = 'WHERE id = 5' AND id = ?", 1).Delete(&testUser{}).Error
+		}, "1"},
+		{"WhereInStringBeforeWhere", func() error {
+			return SetSpanToGorm(ctx, db).Model(&testUser{}).Where("id = ?", 1).
+				UpdateColumn("name", gorm.Expr("'WHERE id IN (' || ?", "x")).Error
+		}, "1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr.Reset()
+			if err := test.run(); err != nil {
+				t.Fatal(err)
+			}
+			spans := finishedStatementSpans(tr)
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if got := spans[0].Tag("db.affected_keys"); got != test.keys {
+				t.Errorf("db.affected_keys = %v, want %v", got, test.keys)
+			}
+		})
+	}
+}
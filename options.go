@@ -0,0 +1,582 @@
+package otgorm
+
+import (
+	"context"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// Option configures tracing callbacks
+type Option func(*options)
+
+type options struct {
+	contextExtractor     func(context.Context) map[string]interface{}
+	errorOperationSuffix string
+	component            string
+	slowThreshold        time.Duration
+	tracer               opentracing.Tracer
+	rootSpans            bool
+	skipDDL              bool
+	metricsHooks         []func(QueryMetrics)
+	explainOnSlow        bool
+	maskLiterals         bool
+	statementHash        bool
+	sampleRate           float64
+	tableSampleRates     map[string]float64
+	tableOperationNames  map[string]string
+	collectionTag        bool
+	defaultOperation     string
+	poolStats            bool
+	errorStack           bool
+	errorFilter          func(error) bool
+	transactionSpans     bool
+	logRecord            func(opentracing.Span, QueryMetrics)
+	primaryKeyTag        bool
+	redactWhere          bool
+	spanName             string
+	rowsAffectedLog      bool
+	maxTagValueLength    int
+	callbackPositions    map[string]CallbackPosition
+	baggage              []baggageItem
+	connectionID         bool
+	minRows              int64
+	affectedKeys         int
+	splitStatements      bool
+	tags                 map[string]interface{}
+	operationTags        map[string]map[string]interface{}
+	semanticConventions  bool
+	skipReads            bool
+	dbType               string
+}
+
+type baggageItem struct {
+	key    string
+	ctxKey interface{}
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		sampleRate:  1,
+		errorFilter: DefaultErrorFilter,
+		spanName:    DefaultSpanName,
+		component:   "gorm",
+		dbType:      "sql",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithContextExtractor sets a function called once per statement with the context passed to SetSpanToGorm,
+// every value of the returned map is set as a span tag
+func WithContextExtractor(fn func(context.Context) map[string]interface{}) Option {
+	return func(o *options) {
+		o.contextExtractor = fn
+	}
+}
+
+// WithErrorOperationSuffix appends suffix to the operation name of spans whose statement failed
+func WithErrorOperationSuffix(suffix string) Option {
+	return func(o *options) {
+		o.errorOperationSuffix = suffix
+	}
+}
+
+// WithComponent sets the component tag of spans, "gorm" by default, empty disables the tag
+func WithComponent(component string) Option {
+	return func(o *options) {
+		o.component = component
+	}
+}
+
+// WithSlowThreshold tags spans of statements running at least d with db.slow
+func WithSlowThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slowThreshold = d
+	}
+}
+
+// WithTracer sets tracer used to start root spans, opentracing.GlobalTracer() is used by default
+func WithTracer(tr opentracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tr
+	}
+}
+
+// WithRootSpans enables tracing of statements without a parent span set by SetSpanToGorm
+func WithRootSpans() Option {
+	return func(o *options) {
+		o.rootSpans = true
+	}
+}
+
+// WithSkipDDL disables tracing of CREATE, ALTER and DROP statements, useful to keep migrations out of traces.
+// Their spans are finished with sampling priority 0 so tracers drop them
+func WithSkipDDL() Option {
+	return func(o *options) {
+		o.skipDDL = true
+	}
+}
+
+// QueryMetrics describes a finished statement, it is passed to the metrics hook
+type QueryMetrics struct {
+	Operation    string
+	Table        string
+	Duration     time.Duration
+	RowsAffected int64
+	Err          error
+}
+
+// WithMetricsHook adds a function called after every statement, including the ones without a span,
+// it may be used several times to feed several collectors
+func WithMetricsHook(fn func(QueryMetrics)) Option {
+	return func(o *options) {
+		o.metricsHooks = append(o.metricsHooks, fn)
+	}
+}
+
+// WithExplainOnSlow logs the plan of statements exceeding the slow threshold set by WithSlowThreshold.
+// The plan is fetched with EXPLAIN on a separate connection within one second, so it is costly and meant for diagnosis only.
+// MySQL, Postgres and SQLite are supported, statements run inside a transaction are not explained
+func WithExplainOnSlow() Option {
+	return func(o *options) {
+		o.explainOnSlow = true
+	}
+}
+
+// WithMaskLiterals replaces string and numeric literals inlined into the statement tag with ?,
+// it hides values of raw SQL which are not passed as bind variables
+func WithMaskLiterals() Option {
+	return func(o *options) {
+		o.maskLiterals = true
+	}
+}
+
+// WithStatementHash tags spans with db.statement.hash, a short hash of the statement with masked literals
+// and placeholders, usable as a low cardinality grouping key
+func WithStatementHash() Option {
+	return func(o *options) {
+		o.statementHash = true
+	}
+}
+
+// WithSampleRate sets fraction of statements traced, from 0 to 1, all statements are traced by default
+func WithSampleRate(rate float64) Option {
+	return func(o *options) {
+		o.sampleRate = rate
+	}
+}
+
+// WithTableSampleRates sets sample rates of statements on given tables, other tables use the WithSampleRate one.
+// The decision is taken before the SQL is built, so the table is the one of the model or set with Table,
+// Raw queries use the WithSampleRate rate even though their db.table tag is taken from the SQL
+func WithTableSampleRates(rates map[string]float64) Option {
+	return func(o *options) {
+		o.tableSampleRates = rates
+	}
+}
+
+// WithTableOperationNames sets span operation names of statements on given tables
+func WithTableOperationNames(names map[string]string) Option {
+	return func(o *options) {
+		o.tableOperationNames = names
+	}
+}
+
+// WithCollectionTag tags spans with db.collection set to the table, same as db.table
+func WithCollectionTag() Option {
+	return func(o *options) {
+		o.collectionTag = true
+	}
+}
+
+// WithDefaultOperation sets db.method of statements whose verb is empty or not a known SQL verb, and of
+// statements which didn't run and have no SQL like creates rejected by a BeforeCreate hook
+func WithDefaultOperation(operation string) Option {
+	return func(o *options) {
+		o.defaultOperation = operation
+	}
+}
+
+// WithPoolStats tags spans with db.pool.wait_ms and db.pool.wait_count, the growth of sql.DBStats wait counters
+// while the statement ran. Stats are read twice per statement and are shared by the whole pool, so concurrent
+// statements see each other's waits. Writes include the wait for the connection of the transaction gorm wraps
+// them in, statements inside transactions begun by the caller are not tagged
+func WithPoolStats() Option {
+	return func(o *options) {
+		o.poolStats = true
+	}
+}
+
+// WithErrorStack tags failed statement spans with db.error.stack when the error or one it wraps carries a stack trace
+func WithErrorStack() Option {
+	return func(o *options) {
+		o.errorStack = true
+	}
+}
+
+// WithErrorFilter sets a function deciding whether the statement error marks the span as failed,
+// DefaultErrorFilter is used by default
+func WithErrorFilter(fn func(error) bool) Option {
+	return func(o *options) {
+		o.errorFilter = fn
+	}
+}
+
+// WithTransactionSpans wraps statements of the transaction gorm starts for create, update and delete into a span
+// finished on commit or rollback, and statements of transactions begun with BeginTx into a span finished by
+// CommitTx or RollbackTx. Both are tagged with db.statement.count. No span is started for other transactions,
+// e.g. the ones begun with db.Begin, or when the gorm:begin_transaction callback is removed
+func WithTransactionSpans() Option {
+	return func(o *options) {
+		o.transactionSpans = true
+	}
+}
+
+// WithPrimaryKeyTag tags spans of statements working with a single record with db.pk, its primary key value.
+// Keys may be sensitive, so it is disabled by default
+func WithPrimaryKeyTag() Option {
+	return func(o *options) {
+		o.primaryKeyTag = true
+	}
+}
+
+// WithRedactWhere masks literals of the WHERE clause in the statement tag, filtered columns and the rest of
+// the statement stay visible for query shape analysis. WithMaskLiterals takes precedence
+func WithRedactWhere() Option {
+	return func(o *options) {
+		o.redactWhere = true
+	}
+}
+
+// WithSpanName sets operation name of statement spans, DefaultSpanName is used by default. Row query spans are
+// named after it followed by their verb like "sql SELECT", or RAW when the verb is unknown. Names set with
+// WithTableOperationNames take precedence over both
+func WithSpanName(name string) Option {
+	return func(o *options) {
+		o.spanName = name
+	}
+}
+
+// WithRowsAffectedLog additionally logs rows affected to the span, for backends not indexing the numeric db.count tag
+func WithRowsAffectedLog() Option {
+	return func(o *options) {
+		o.rowsAffectedLog = true
+	}
+}
+
+// WithMaxTagValueLength truncates every string tag value set on spans, like the statement or error ones, to n runes
+func WithMaxTagValueLength(n int) Option {
+	return func(o *options) {
+		o.maxTagValueLength = n
+	}
+}
+
+// CallbackPosition places tracing callbacks of an operation relative to other registered gorm callbacks,
+// empty fields keep the default gorm:<operation> anchor
+type CallbackPosition struct {
+	// Before is the callback the span is started before
+	Before string
+	// After is the callback the span is finished after
+	After string
+}
+
+// WithCallbackPosition registers tracing callbacks of operation, one of create, query, update, delete
+// and row_query, at pos to order them deterministically with other plugins
+func WithCallbackPosition(operation string, pos CallbackPosition) Option {
+	return func(o *options) {
+		if o.callbackPositions == nil {
+			o.callbackPositions = map[string]CallbackPosition{}
+		}
+		o.callbackPositions[operation] = pos
+	}
+}
+
+// WithBaggageFromContext sets baggage item key of statement spans to the value stored under ctxKey in the context
+// passed to SetSpanToGorm, so it propagates to deeper spans. Statements whose context has no such value are skipped
+func WithBaggageFromContext(key string, ctxKey interface{}) Option {
+	return func(o *options) {
+		o.baggage = append(o.baggage, baggageItem{key: key, ctxKey: ctxKey})
+	}
+}
+
+// WithConnectionID tags statements run inside a transaction with db.connection_id, the server side id of
+// their connection from CONNECTION_ID() on MySQL or pg_backend_pid() on Postgres. It costs one extra query per
+// transaction. database/sql doesn't tell which pooled connection other statements use, so they are not tagged
+func WithConnectionID() Option {
+	return func(o *options) {
+		o.connectionID = true
+	}
+}
+
+// WithMinRows drops spans of queries returning n rows or less, failed queries are always kept.
+// Dropped spans are finished with sampling priority 0
+func WithMinRows(n int64) Option {
+	return func(o *options) {
+		o.minRows = n
+	}
+}
+
+// WithAffectedKeys tags update and delete spans with db.affected_keys, up to max primary key values
+// the statement filters on. Keys may be sensitive, so it is disabled by default
+func WithAffectedKeys(max int) Option {
+	return func(o *options) {
+		o.affectedKeys = max
+	}
+}
+
+// WithSplitStatements adds a child span per statement to row query spans executing several statements at once,
+// semicolons inside quoted strings and comments don't split
+func WithSplitStatements() Option {
+	return func(o *options) {
+		o.splitStatements = true
+	}
+}
+
+// WithTags sets tags on every span
+func WithTags(tags map[string]interface{}) Option {
+	return func(o *options) {
+		o.tags = tags
+	}
+}
+
+// WithOperationTags sets tags on spans of operation, one of create, query, update, delete and row_query,
+// they take precedence over WithTags ones
+func WithOperationTags(operation string, tags map[string]interface{}) Option {
+	return func(o *options) {
+		if o.operationTags == nil {
+			o.operationTags = map[string]map[string]interface{}{}
+		}
+		o.operationTags[operation] = tags
+	}
+}
+
+// WithSemanticConventions additionally sets OpenTelemetry database attributes db.system, db.operation
+// and db.operation.name, the legacy tags are kept
+func WithSemanticConventions() Option {
+	return func(o *options) {
+		o.semanticConventions = true
+	}
+}
+
+// WithSkipReads drops spans of successful queries and SELECT row queries, writes and failed reads are kept.
+// Dropped spans are finished with sampling priority 0
+func WithSkipReads() Option {
+	return func(o *options) {
+		o.skipReads = true
+	}
+}
+
+// WithDBType sets the db.type tag of spans, "sql" by default
+func WithDBType(dbType string) Option {
+	return func(o *options) {
+		o.dbType = dbType
+	}
+}
+
+// Options is a chainable builder of tracing options, it can be built once and reused for many DBs
+type Options struct {
+	opts []Option
+}
+
+// NewOptions returns empty options builder
+func NewOptions() *Options {
+	return &Options{}
+}
+
+// Build returns an Option applying everything set on the builder, pass it to AddGormCallbacksWithOptions
+func (b *Options) Build() Option {
+	opts := append([]Option(nil), b.opts...)
+	return func(o *options) {
+		for _, opt := range opts {
+			opt(o)
+		}
+	}
+}
+
+func (b *Options) add(opt Option) *Options {
+	b.opts = append(b.opts, opt)
+	return b
+}
+
+// ContextExtractor is the builder form of WithContextExtractor
+func (b *Options) ContextExtractor(fn func(context.Context) map[string]interface{}) *Options {
+	return b.add(WithContextExtractor(fn))
+}
+
+// ErrorOperationSuffix is the builder form of WithErrorOperationSuffix
+func (b *Options) ErrorOperationSuffix(suffix string) *Options {
+	return b.add(WithErrorOperationSuffix(suffix))
+}
+
+// Component is the builder form of WithComponent
+func (b *Options) Component(component string) *Options {
+	return b.add(WithComponent(component))
+}
+
+// SlowThreshold is the builder form of WithSlowThreshold
+func (b *Options) SlowThreshold(d time.Duration) *Options {
+	return b.add(WithSlowThreshold(d))
+}
+
+// Tracer is the builder form of WithTracer
+func (b *Options) Tracer(tr opentracing.Tracer) *Options {
+	return b.add(WithTracer(tr))
+}
+
+// RootSpans is the builder form of WithRootSpans
+func (b *Options) RootSpans() *Options {
+	return b.add(WithRootSpans())
+}
+
+// SkipDDL is the builder form of WithSkipDDL
+func (b *Options) SkipDDL() *Options {
+	return b.add(WithSkipDDL())
+}
+
+// MetricsHook is the builder form of WithMetricsHook
+func (b *Options) MetricsHook(fn func(QueryMetrics)) *Options {
+	return b.add(WithMetricsHook(fn))
+}
+
+// ExplainOnSlow is the builder form of WithExplainOnSlow
+func (b *Options) ExplainOnSlow() *Options {
+	return b.add(WithExplainOnSlow())
+}
+
+// MaskLiterals is the builder form of WithMaskLiterals
+func (b *Options) MaskLiterals() *Options {
+	return b.add(WithMaskLiterals())
+}
+
+// StatementHash is the builder form of WithStatementHash
+func (b *Options) StatementHash() *Options {
+	return b.add(WithStatementHash())
+}
+
+// SampleRate is the builder form of WithSampleRate
+func (b *Options) SampleRate(rate float64) *Options {
+	return b.add(WithSampleRate(rate))
+}
+
+// TableSampleRates is the builder form of WithTableSampleRates
+func (b *Options) TableSampleRates(rates map[string]float64) *Options {
+	return b.add(WithTableSampleRates(rates))
+}
+
+// TableOperationNames is the builder form of WithTableOperationNames
+func (b *Options) TableOperationNames(names map[string]string) *Options {
+	return b.add(WithTableOperationNames(names))
+}
+
+// CollectionTag is the builder form of WithCollectionTag
+func (b *Options) CollectionTag() *Options {
+	return b.add(WithCollectionTag())
+}
+
+// DefaultOperation is the builder form of WithDefaultOperation
+func (b *Options) DefaultOperation(operation string) *Options {
+	return b.add(WithDefaultOperation(operation))
+}
+
+// PoolStats is the builder form of WithPoolStats
+func (b *Options) PoolStats() *Options {
+	return b.add(WithPoolStats())
+}
+
+// ErrorStack is the builder form of WithErrorStack
+func (b *Options) ErrorStack() *Options {
+	return b.add(WithErrorStack())
+}
+
+// ErrorFilter is the builder form of WithErrorFilter
+func (b *Options) ErrorFilter(fn func(error) bool) *Options {
+	return b.add(WithErrorFilter(fn))
+}
+
+// TransactionSpans is the builder form of WithTransactionSpans
+func (b *Options) TransactionSpans() *Options {
+	return b.add(WithTransactionSpans())
+}
+
+// PrimaryKeyTag is the builder form of WithPrimaryKeyTag
+func (b *Options) PrimaryKeyTag() *Options {
+	return b.add(WithPrimaryKeyTag())
+}
+
+// RedactWhere is the builder form of WithRedactWhere
+func (b *Options) RedactWhere() *Options {
+	return b.add(WithRedactWhere())
+}
+
+// SpanName is the builder form of WithSpanName
+func (b *Options) SpanName(name string) *Options {
+	return b.add(WithSpanName(name))
+}
+
+// RowsAffectedLog is the builder form of WithRowsAffectedLog
+func (b *Options) RowsAffectedLog() *Options {
+	return b.add(WithRowsAffectedLog())
+}
+
+// MaxTagValueLength is the builder form of WithMaxTagValueLength
+func (b *Options) MaxTagValueLength(n int) *Options {
+	return b.add(WithMaxTagValueLength(n))
+}
+
+// CallbackPosition is the builder form of WithCallbackPosition
+func (b *Options) CallbackPosition(operation string, pos CallbackPosition) *Options {
+	return b.add(WithCallbackPosition(operation, pos))
+}
+
+// BaggageFromContext is the builder form of WithBaggageFromContext
+func (b *Options) BaggageFromContext(key string, ctxKey interface{}) *Options {
+	return b.add(WithBaggageFromContext(key, ctxKey))
+}
+
+// ConnectionID is the builder form of WithConnectionID
+func (b *Options) ConnectionID() *Options {
+	return b.add(WithConnectionID())
+}
+
+// MinRows is the builder form of WithMinRows
+func (b *Options) MinRows(n int64) *Options {
+	return b.add(WithMinRows(n))
+}
+
+// AffectedKeys is the builder form of WithAffectedKeys
+func (b *Options) AffectedKeys(max int) *Options {
+	return b.add(WithAffectedKeys(max))
+}
+
+// SplitStatements is the builder form of WithSplitStatements
+func (b *Options) SplitStatements() *Options {
+	return b.add(WithSplitStatements())
+}
+
+// Tags is the builder form of WithTags
+func (b *Options) Tags(tags map[string]interface{}) *Options {
+	return b.add(WithTags(tags))
+}
+
+// OperationTags is the builder form of WithOperationTags
+func (b *Options) OperationTags(operation string, tags map[string]interface{}) *Options {
+	return b.add(WithOperationTags(operation, tags))
+}
+
+// SemanticConventions is the builder form of WithSemanticConventions
+func (b *Options) SemanticConventions() *Options {
+	return b.add(WithSemanticConventions())
+}
+
+// SkipReads is the builder form of WithSkipReads
+func (b *Options) SkipReads() *Options {
+	return b.add(WithSkipReads())
+}
+
+// DBType is the builder form of WithDBType
+func (b *Options) DBType(dbType string) *Options {
+	return b.add(WithDBType(dbType))
+}
@@ -2,17 +2,38 @@ package otgorm
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"math/rand"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/jinzhu/gorm"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 )
 
 const (
 	parentSpanGormKey = "opentracingParentSpan"
 	spanGormKey       = "opentracingSpan"
+	contextGormKey    = "opentracingContext"
+	startTimeGormKey  = "opentracingStartTime"
+	poolStatsGormKey  = "opentracingPoolStats"
+	connIDGormKey     = "opentracingConnectionID"
+	retryGormKey      = "opentracingRetryAttempt"
+	txSpanGormKey     = "opentracingTransactionSpan"
+	txCountGormKey    = "opentracingTransactionStatementCount"
+	userTxGormKey     = "opentracingUserTransaction"
+)
+
+const (
+	// DefaultSpanName is the operation name of statement spans unless changed by WithSpanName or WithTableOperationNames
+	DefaultSpanName = "sql"
+	// DefaultTransactionSpanName is the operation name of transaction spans
+	DefaultTransactionSpanName = "sql.transaction"
 )
 
 // SetSpanToGorm sets span to gorm settings, returns cloned DB
@@ -24,12 +45,58 @@ func SetSpanToGorm(ctx context.Context, db *gorm.DB) *gorm.DB {
 	if parentSpan == nil {
 		return db
 	}
-	return db.Set(parentSpanGormKey, parentSpan)
+	return db.Set(parentSpanGormKey, parentSpan).Set(contextGormKey, ctx)
+}
+
+// SetSpanToGormCtx sets span to gorm settings like SetSpanToGorm, returns context containing the span and cloned DB.
+// If ctx carries no span a new one is started from the global tracer, the caller is responsible for finishing it
+func SetSpanToGormCtx(ctx context.Context, db *gorm.DB) (context.Context, *gorm.DB) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opentracing.SpanFromContext(ctx) == nil {
+		_, ctx = opentracing.StartSpanFromContext(ctx, DefaultSpanName)
+	}
+	return ctx, SetSpanToGorm(ctx, db)
+}
+
+// StartGormSpan starts span name as a child of the span in ctx and sets it to gorm settings, statements run on
+// the returned DB nest under it. Finish the span once done with opentracing.SpanFromContext(ctx).Finish()
+// on the returned context
+func StartGormSpan(ctx context.Context, db *gorm.DB, name string) (context.Context, *gorm.DB) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tr := opentracing.GlobalTracer()
+	if parentSpan := opentracing.SpanFromContext(ctx); parentSpan != nil && parentSpan.Tracer() != nil {
+		tr = parentSpan.Tracer()
+	}
+	_, ctx = opentracing.StartSpanFromContextWithTracer(ctx, tr, name)
+	return ctx, SetSpanToGorm(ctx, db)
+}
+
+// SetRetryAttempt marks statements run on the returned DB as retry attempt number attempt,
+// their spans are tagged with db.retry.attempt. Every attempt gets its own span, they are siblings under the span
+// set by SetSpanToGorm:
+//
+//	for attempt := 1; attempt <= 3; attempt++ {
+//		err = otgorm.SetRetryAttempt(otgorm.SetSpanToGorm(ctx, db), attempt).First(&user).Error
+//		if err == nil {
+//			break
+//		}
+//	}
+func SetRetryAttempt(db *gorm.DB, attempt int) *gorm.DB {
+	return db.Set(retryGormKey, attempt)
 }
 
 // AddGormCallbacks adds callbacks for tracing, you should call SetSpanToGorm to make them work
 func AddGormCallbacks(db *gorm.DB) {
-	callbacks := newCallbacks()
+	AddGormCallbacksWithOptions(db)
+}
+
+// AddGormCallbacksWithOptions adds callbacks for tracing configured by opts, you should call SetSpanToGorm to make them work
+func AddGormCallbacksWithOptions(db *gorm.DB, opts ...Option) {
+	callbacks := newCallbacks(newOptions(opts...))
 	registerCallbacks(db, "create", callbacks)
 	registerCallbacks(db, "query", callbacks)
 	registerCallbacks(db, "update", callbacks)
@@ -37,76 +104,531 @@ func AddGormCallbacks(db *gorm.DB) {
 	registerCallbacks(db, "row_query", callbacks)
 }
 
-type callbacks struct{}
+// TraceReadsOnly adds tracing callbacks configured by opts for query and row_query only, writes stay untraced
+func TraceReadsOnly(db *gorm.DB, opts ...Option) {
+	callbacks := newCallbacks(newOptions(opts...))
+	registerCallbacks(db, "query", callbacks)
+	registerCallbacks(db, "row_query", callbacks)
+}
+
+// callbackKinds are the gorm callback kinds tracing callbacks are registered for
+var callbackKinds = []string{"create", "query", "update", "delete", "row_query"}
 
-func newCallbacks() *callbacks {
-	return &callbacks{}
+// gormKeys are the settings this package stores on gorm DBs
+var gormKeys = []string{
+	parentSpanGormKey, spanGormKey, contextGormKey, startTimeGormKey, poolStatsGormKey,
+	connIDGormKey, retryGormKey, txSpanGormKey, txCountGormKey, txOptionsGormKey, userTxGormKey,
 }
 
-func (c *callbacks) beforeCreate(scope *gorm.Scope)   { c.before(scope) }
-func (c *callbacks) afterCreate(scope *gorm.Scope)    { c.after(scope, "INSERT") }
-func (c *callbacks) beforeQuery(scope *gorm.Scope)    { c.before(scope) }
-func (c *callbacks) afterQuery(scope *gorm.Scope)     { c.after(scope, "SELECT") }
-func (c *callbacks) beforeUpdate(scope *gorm.Scope)   { c.before(scope) }
-func (c *callbacks) afterUpdate(scope *gorm.Scope)    { c.after(scope, "UPDATE") }
-func (c *callbacks) beforeDelete(scope *gorm.Scope)   { c.before(scope) }
-func (c *callbacks) afterDelete(scope *gorm.Scope)    { c.after(scope, "DELETE") }
-func (c *callbacks) beforeRowQuery(scope *gorm.Scope) { c.before(scope) }
-func (c *callbacks) afterRowQuery(scope *gorm.Scope)  { c.after(scope, "") }
+// RemoveGormCallbacks removes tracing callbacks added by AddGormCallbacks, AddGormCallbacksWithOptions or TraceReadsOnly
+func RemoveGormCallbacks(db *gorm.DB) {
+	for _, kind := range callbackKinds {
+		for _, name := range callbackNames(kind) {
+			if callbackProcessor(db, kind).Get(name) != nil {
+				callbackProcessor(db, kind).Remove(name)
+			}
+		}
+	}
+}
 
-func (c *callbacks) before(scope *gorm.Scope) {
-	val, ok := scope.Get(parentSpanGormKey)
+// ResetGormCallbacks removes tracing callbacks like RemoveGormCallbacks, clears settings of this package
+// set on db with InstantSet and re-enables callbacks stopped by DisableCallback, the flags are global so this
+// affects all DBs. Returns an error naming callbacks still registered afterwards.
+// Settings of DBs cloned from db by SetSpanToGorm and friends are left as is, drop the clones instead
+func ResetGormCallbacks(db *gorm.DB) error {
+	RemoveGormCallbacks(db)
+	for _, kind := range callbackKinds {
+		EnableCallback(kind)
+	}
+	for _, key := range gormKeys {
+		if _, ok := db.Get(key); ok {
+			db.InstantSet(key, nil)
+		}
+	}
+	var remaining []string
+	for _, kind := range callbackKinds {
+		for _, name := range callbackNames(kind) {
+			if callbackProcessor(db, kind).Get(name) != nil {
+				remaining = append(remaining, name)
+			}
+		}
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf("otgorm: tracing callbacks still registered: %v", strings.Join(remaining, ", "))
+	}
+	return nil
+}
+
+// callbackNames returns names of tracing callbacks registerCallbacks may register for kind
+func callbackNames(kind string) []string {
+	names := []string{fmt.Sprintf("tracing:%v_before", kind), fmt.Sprintf("tracing:%v_after", kind)}
+	if kind == "create" || kind == "update" || kind == "delete" {
+		names = append(names, fmt.Sprintf("tracing:%v_commit", kind), fmt.Sprintf("tracing:%v_pool", kind))
+	}
+	return names
+}
+
+// callbackProcessor returns a new processor of kind, gorm keeps state in it so it can't be reused
+func callbackProcessor(db *gorm.DB, kind string) *gorm.CallbackProcessor {
+	switch kind {
+	case "create":
+		return db.Callback().Create()
+	case "query":
+		return db.Callback().Query()
+	case "update":
+		return db.Callback().Update()
+	case "delete":
+		return db.Callback().Delete()
+	default:
+		return db.Callback().RowQuery()
+	}
+}
+
+// disabledCallbacks holds a flag per callback kind, non-zero while DisableCallback is in effect
+var disabledCallbacks = map[string]*int32{
+	"create":    new(int32),
+	"query":     new(int32),
+	"update":    new(int32),
+	"delete":    new(int32),
+	"row_query": new(int32),
+}
+
+// DisableCallback stops tracing of name, one of create, query, update, delete and row_query, on all DBs
+// without unregistering callbacks until EnableCallback is called, spans of statements already running are still
+// finished. Unknown names are ignored
+func DisableCallback(name string) {
+	if flag, ok := disabledCallbacks[name]; ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+// EnableCallback resumes tracing of name stopped by DisableCallback
+func EnableCallback(name string) {
+	if flag, ok := disabledCallbacks[name]; ok {
+		atomic.StoreInt32(flag, 0)
+	}
+}
+
+func callbackDisabled(name string) bool {
+	flag, ok := disabledCallbacks[name]
+	return ok && atomic.LoadInt32(flag) != 0
+}
+
+type callbacks struct {
+	opts    *options
+	connIDs connectionIDs
+}
+
+func newCallbacks(opts *options) *callbacks {
+	return &callbacks{opts: opts}
+}
+
+func (c *callbacks) beforeCreate(scope *gorm.Scope)   { c.before(scope, "create") }
+func (c *callbacks) afterCreate(scope *gorm.Scope)    { c.after(scope, "create", "INSERT") }
+func (c *callbacks) beforeQuery(scope *gorm.Scope)    { c.before(scope, "query") }
+func (c *callbacks) afterQuery(scope *gorm.Scope)     { c.after(scope, "query", "SELECT") }
+func (c *callbacks) beforeUpdate(scope *gorm.Scope)   { c.before(scope, "update") }
+func (c *callbacks) afterUpdate(scope *gorm.Scope)    { c.after(scope, "update", "UPDATE") }
+func (c *callbacks) beforeDelete(scope *gorm.Scope)   { c.before(scope, "delete") }
+func (c *callbacks) afterDelete(scope *gorm.Scope)    { c.after(scope, "delete", "DELETE") }
+func (c *callbacks) beforeRowQuery(scope *gorm.Scope) { c.before(scope, "row_query") }
+func (c *callbacks) afterRowQuery(scope *gorm.Scope)  { c.after(scope, "row_query", "") }
+
+// tracer returns configured tracer, falls back to the global one
+func (c *callbacks) tracer() opentracing.Tracer {
+	if c.opts.tracer != nil {
+		return c.opts.tracer
+	}
+	return opentracing.GlobalTracer()
+}
+
+// operationName returns span operation name for statement on table
+func (c *callbacks) operationName(table string) string {
+	if name, ok := c.opts.tableOperationNames[table]; ok {
+		return name
+	}
+	return c.opts.spanName
+}
+
+// sampled decides whether statement on table is traced according to the sample rates
+func (c *callbacks) sampled(table string) bool {
+	rate, ok := c.opts.tableSampleRates[table]
 	if !ok {
+		rate = c.opts.sampleRate
+	}
+	return rate >= 1 || rate > 0 && rand.Float64() < rate
+}
+
+// setTag sets tag on sp, string values are truncated to the WithMaxTagValueLength limit
+func (c *callbacks) setTag(sp opentracing.Span, key string, value interface{}) {
+	if str, ok := value.(string); ok && c.opts.maxTagValueLength > 0 && utf8.RuneCountInString(str) > c.opts.maxTagValueLength {
+		value = string([]rune(str)[:c.opts.maxTagValueLength])
+	}
+	sp.SetTag(key, value)
+}
+
+// setCommonTags sets tags shared by statement and transaction spans
+func (c *callbacks) setCommonTags(sp opentracing.Span) {
+	c.setTag(sp, string(ext.DBType), c.opts.dbType)
+	if c.opts.component != "" {
+		c.setTag(sp, string(ext.Component), c.opts.component)
+	}
+	for k, v := range c.opts.tags {
+		c.setTag(sp, k, v)
+	}
+}
+
+func (c *callbacks) before(scope *gorm.Scope, kind string) {
+	scope.Set(startTimeGormKey, time.Now())
+	if callbackDisabled(kind) {
+		return
+	}
+	var tr opentracing.Tracer
+	var spanOpts []opentracing.StartSpanOption
+	if val, ok := gormValue(scope, txSpanGormKey); ok {
+		txSpan := val.(opentracing.Span)
+		tr = txSpan.Tracer()
+		spanOpts = append(spanOpts, opentracing.ChildOf(txSpan.Context()))
+	} else if val, ok := gormValue(scope, parentSpanGormKey); ok {
+		parentSpan := val.(opentracing.Span)
+		tr = parentSpan.Tracer()
+		spanOpts = append(spanOpts, opentracing.ChildOf(parentSpan.Context()))
+	} else if c.opts.rootSpans {
+		tr = c.tracer()
+	} else {
 		return
 	}
-	parentSpan := val.(opentracing.Span)
-	tr := parentSpan.Tracer()
-	sp := tr.StartSpan("sql", opentracing.ChildOf(parentSpan.Context()))
-	ext.DBType.Set(sp, "sql")
+	if tr == nil {
+		// spans of some mock and noop setups have no tracer
+		tr = c.tracer()
+	}
+	table := scope.TableName()
+	if !c.sampled(table) {
+		return
+	}
+	if c.opts.transactionSpans && startedTransaction(scope) {
+		txSpan := tr.StartSpan(DefaultTransactionSpanName, spanOpts...)
+		c.setCommonTags(txSpan)
+		scope.Set(txSpanGormKey, txSpan)
+		scope.Set(txCountGormKey, new(int64))
+		spanOpts = []opentracing.StartSpanOption{opentracing.ChildOf(txSpan.Context())}
+	} else if utx, ok := userTransaction(scope); ok && c.opts.transactionSpans {
+		txSpan := utx.start(c, tr, spanOpts)
+		scope.Set(txCountGormKey, &utx.count)
+		spanOpts = []opentracing.StartSpanOption{opentracing.ChildOf(txSpan.Context())}
+	}
+	sp := tr.StartSpan(c.operationName(table), spanOpts...)
+	c.setCommonTags(sp)
+	if val, ok := gormValue(scope, contextGormKey); ok {
+		ctx := val.(context.Context)
+		if c.opts.contextExtractor != nil {
+			for k, v := range c.opts.contextExtractor(ctx) {
+				c.setTag(sp, k, v)
+			}
+		}
+		for _, item := range c.opts.baggage {
+			if v := ctx.Value(item.ctxKey); v != nil {
+				sp.SetBaggageItem(item.key, fmt.Sprint(v))
+			}
+		}
+	}
+	if c.opts.poolStats {
+		c.poolSnapshot(scope)
+	}
+	if c.opts.connectionID {
+		// fetched before the statement as the connection may be busy reading rows after it
+		if id, ok := c.connIDs.get(scope); ok {
+			scope.Set(connIDGormKey, id)
+		}
+	}
 	scope.Set(spanGormKey, sp)
 }
 
-func (c *callbacks) after(scope *gorm.Scope, operation string) {
-	val, ok := scope.Get(spanGormKey)
-	if !ok {
+// after finishes the span, operation is used as db.method when it can't be detected from the SQL
+func (c *callbacks) after(scope *gorm.Scope, kind, operation string) {
+	// SQL is empty when the statement didn't run, a failed BeforeCreate hook for example
+	empty := strings.TrimSpace(scope.SQL) == ""
+	if empty && c.opts.defaultOperation != "" {
+		operation = c.opts.defaultOperation
+	}
+	if c.opts.skipDDL && isDDL(scope.SQL) {
+		if val, ok := gormValue(scope, spanGormKey); ok {
+			discardSpan(val.(opentracing.Span))
+		}
 		return
 	}
-	if strings.TrimSpace(scope.SQL) == "" {
+	if count, ok := gormValue(scope, txCountGormKey); ok && !empty {
+		atomic.AddInt64(count.(*int64), 1)
+	}
+	if verb := sqlVerb(scope.SQL); verb != "" && (c.opts.defaultOperation == "" || knownVerbs[verb]) {
+		operation = verb
+	}
+	if operation == "" {
+		operation = c.opts.defaultOperation
+	}
+	sourceTable := ""
+	if operation == "INSERT" {
+		if source, ok := insertSelectSource(scope.SQL); ok {
+			operation, sourceTable = "INSERT_SELECT", source
+		}
+	}
+	table := tableName(scope)
+	var duration time.Duration
+	if start, ok := gormValue(scope, startTimeGormKey); ok {
+		duration = time.Since(start.(time.Time))
+	}
+	metrics := QueryMetrics{
+		Operation:    operation,
+		Table:        table,
+		Duration:     duration,
+		RowsAffected: scope.DB().RowsAffected,
+		Err:          scope.DB().Error,
+	}
+	for _, hook := range c.opts.metricsHooks {
+		hook(metrics)
+	}
+	val, ok := gormValue(scope, spanGormKey)
+	if c.opts.logRecord != nil {
+		sp, _ := val.(opentracing.Span)
+		c.opts.logRecord(sp, metrics)
+	}
+	if !ok {
 		return
 	}
 	sp := val.(opentracing.Span)
-	if operation == "" {
-		operation = strings.ToUpper(strings.Split(scope.SQL, " ")[0])
-	}
-	ext.Error.Set(sp, scope.HasError())
-	ext.DBStatement.Set(sp, scope.SQL)
-	sp.SetTag("db.table", scope.TableName())
-	sp.SetTag("db.method", operation)
-	sp.SetTag("db.err", scope.HasError())
-	sp.SetTag("db.count", scope.DB().RowsAffected)
+	failed := scope.HasError() && c.opts.errorFilter(scope.DB().Error)
+	if kind == "query" && c.opts.minRows > 0 && !failed && returnedRows(scope) <= c.opts.minRows {
+		discardSpan(sp)
+		return
+	}
+	if c.opts.skipReads && !failed && (kind == "query" || operation == "SELECT") {
+		discardSpan(sp)
+		return
+	}
+	name := c.operationName(table)
+	if _, ok := c.opts.tableOperationNames[table]; !ok && kind == "row_query" {
+		// raw row queries get their verb appended to tell them apart
+		verb := operation
+		if verb == "" {
+			verb = "RAW"
+		}
+		name = c.opts.spanName + " " + verb
+	}
+	if failed {
+		name += c.opts.errorOperationSuffix
+	}
+	sp.SetOperationName(name)
+	ext.Error.Set(sp, failed)
+	if !empty {
+		c.setTag(sp, string(ext.DBStatement), c.statement(scope.SQL))
+		if c.opts.statementHash {
+			c.setTag(sp, "db.statement.hash", fingerprint(scope.SQL, scope.Dialect().GetName()))
+		}
+	}
+	c.setTag(sp, "db.vars.count", len(placeholders(scope.SQL, scope.Dialect().GetName())))
+	c.setTag(sp, "db.table", table)
+	if c.opts.collectionTag {
+		c.setTag(sp, "db.collection", table)
+	}
+	if sourceTable != "" {
+		c.setTag(sp, "db.source_table", sourceTable)
+	}
+	if c.opts.semanticConventions {
+		c.setTag(sp, "db.system", dbSystem(scope.Dialect().GetName()))
+		c.setTag(sp, "db.operation", operation)
+		c.setTag(sp, "db.operation.name", strings.TrimSpace(operation+" "+table))
+	}
+	if kind == "query" || kind == "row_query" {
+		joins := sqlJoins(scope.SQL)
+		if kind == "query" {
+			c.setTag(sp, "db.join_count", joinCount(scope.SQL))
+		}
+		if len(joins) > maxJoinsTagged {
+			joins = joins[:maxJoinsTagged]
+		}
+		if len(joins) > 0 {
+			c.setTag(sp, "db.joins", strings.Join(joins, ","))
+		}
+	}
+	c.setTag(sp, "db.method", operation)
+	c.setTag(sp, "db.gorm.callback", kind)
+	// the transaction gorm wraps single writes in is not one of the caller's
+	c.setTag(sp, "db.in_transaction", inTransaction(scope) && !startedTransaction(scope))
+	if level := isolationLevel(scope); level != "" {
+		c.setTag(sp, "db.isolation", level)
+	}
+	if readOnly(scope) {
+		c.setTag(sp, "db.readonly", true)
+	}
+	c.setTag(sp, "db.err", scope.HasError())
+	if errKind := errorKind(scope.DB().Error); errKind != "" {
+		c.setTag(sp, "db.error.kind", errKind)
+		if errKind == "deadlock" {
+			// ask the tracer to keep the trace for deadlock analysis
+			ext.SamplingPriority.Set(sp, 1)
+		}
+	}
+	if code, ok := errorCode(scope.DB().Error); ok {
+		c.setTag(sp, "db.error.code", code)
+	}
+	if state := sqlState(scope.DB().Error); state != "" {
+		c.setTag(sp, "db.sqlstate", state)
+	}
+	if c.opts.errorStack {
+		if stack := errorStack(scope.DB().Error); stack != "" {
+			c.setTag(sp, "db.error.stack", stack)
+		}
+	}
+	c.setTag(sp, "db.count", scope.DB().RowsAffected)
+	if c.opts.rowsAffectedLog {
+		sp.LogFields(log.String("event", "rows_affected"), log.Int64("db.count", scope.DB().RowsAffected))
+	}
+	if c.opts.affectedKeys > 0 && (kind == "update" || kind == "delete") {
+		if keys, more := affectedKeys(scope, c.opts.affectedKeys); len(keys) > 0 {
+			if more {
+				keys = append(keys, "...")
+			}
+			c.setTag(sp, "db.affected_keys", strings.Join(keys, ","))
+		}
+	}
+	if c.opts.primaryKeyTag {
+		if pk := primaryKey(scope); pk != "" {
+			c.setTag(sp, "db.pk", pk)
+		}
+	}
+	if stmts := splitStatements(scope.SQL); len(stmts) > 1 {
+		c.setTag(sp, "db.multi_statement", true)
+		if c.opts.splitStatements && kind == "row_query" {
+			c.statementSpans(sp, stmts, time.Now().Add(-duration))
+		}
+	}
+	if attempt, ok := gormValue(scope, retryGormKey); ok {
+		c.setTag(sp, "db.retry.attempt", attempt)
+	}
+	if id, ok := gormValue(scope, connIDGormKey); ok {
+		c.setTag(sp, "db.connection_id", id)
+	}
+	if val, ok := gormValue(scope, poolStatsGormKey); ok {
+		snapshot := val.(poolStats)
+		before, after := snapshot.stats, snapshot.db.Stats()
+		c.setTag(sp, "db.pool.wait_ms", float64(after.WaitDuration-before.WaitDuration)/float64(time.Millisecond))
+		c.setTag(sp, "db.pool.wait_count", after.WaitCount-before.WaitCount)
+	}
+	if c.opts.slowThreshold > 0 && duration >= c.opts.slowThreshold {
+		c.setTag(sp, "db.slow", true)
+		if c.opts.explainOnSlow && !scope.HasError() {
+			explain(scope, sp)
+		}
+	}
+	for k, v := range c.opts.operationTags[kind] {
+		c.setTag(sp, k, v)
+	}
+	sp.Finish()
+}
+
+// poolStats is the pool of a statement with its stats read before the statement got a connection
+type poolStats struct {
+	db    *sql.DB
+	stats sql.DBStats
+}
+
+// poolSnapshot reads pool stats of the scope, it runs before gorm begins the default transaction of writes
+// as well so their wait for a connection is included. Statements inside a transaction are skipped
+func (c *callbacks) poolSnapshot(scope *gorm.Scope) {
+	if db, ok := scope.SQLDB().(*sql.DB); ok {
+		scope.Set(poolStatsGormKey, poolStats{db: db, stats: db.Stats()})
+	}
+}
+
+// discardSpan finishes sp marked as unsampled, tracers buffering a trace until all of its spans finish
+// would hold or lose the trace if it was left unfinished
+func discardSpan(sp opentracing.Span) {
+	ext.SamplingPriority.Set(sp, 0)
 	sp.Finish()
 }
 
+// statement returns sql as set to the statement tag, with literals masked according to the options
+func (c *callbacks) statement(sql string) string {
+	if c.opts.maskLiterals {
+		return maskLiterals(sql)
+	}
+	if c.opts.redactWhere {
+		return redactWhere(sql)
+	}
+	return sql
+}
+
+// statementSpans adds a child span of sp for each of stmts, they all share the timing of sp
+// as statements of one execution can't be timed separately
+func (c *callbacks) statementSpans(sp opentracing.Span, stmts []string, start time.Time) {
+	tr := sp.Tracer()
+	if tr == nil {
+		tr = c.tracer()
+	}
+	for _, stmt := range stmts {
+		child := tr.StartSpan(c.opts.spanName, opentracing.ChildOf(sp.Context()), opentracing.StartTime(start))
+		c.setCommonTags(child)
+		c.setTag(child, string(ext.DBStatement), c.statement(stmt))
+		c.setTag(child, "db.method", sqlVerb(stmt))
+		child.Finish()
+	}
+}
+
+// gormValue returns setting key of scope, settings cleared by ResetGormCallbacks are reported as missing
+func gormValue(scope *gorm.Scope, key string) (interface{}, bool) {
+	val, ok := scope.Get(key)
+	return val, ok && val != nil
+}
+
 func registerCallbacks(db *gorm.DB, name string, c *callbacks) {
 	beforeName := fmt.Sprintf("tracing:%v_before", name)
 	afterName := fmt.Sprintf("tracing:%v_after", name)
+	commitName := fmt.Sprintf("tracing:%v_commit", name)
+	poolName := fmt.Sprintf("tracing:%v_pool", name)
 	gormCallbackName := fmt.Sprintf("gorm:%v", name)
+	beforeAnchor, afterAnchor := gormCallbackName, gormCallbackName
+	if pos, ok := c.opts.callbackPositions[name]; ok {
+		if pos.Before != "" {
+			beforeAnchor = pos.Before
+		}
+		if pos.After != "" {
+			afterAnchor = pos.After
+		}
+	}
 	// gorm does some magic, if you pass CallbackProcessor here - nothing works
 	switch name {
 	case "create":
-		db.Callback().Create().Before(gormCallbackName).Register(beforeName, c.beforeCreate)
-		db.Callback().Create().After(gormCallbackName).Register(afterName, c.afterCreate)
+		db.Callback().Create().Before(beforeAnchor).Register(beforeName, c.beforeCreate)
+		db.Callback().Create().After(afterAnchor).Register(afterName, c.afterCreate)
+		if c.opts.transactionSpans {
+			db.Callback().Create().After(gormCommitCallbackName).Register(commitName, c.afterCommit)
+		}
+		if c.opts.poolStats {
+			db.Callback().Create().Before(gormBeginCallbackName).Register(poolName, c.poolSnapshot)
+		}
 	case "query":
-		db.Callback().Query().Before(gormCallbackName).Register(beforeName, c.beforeQuery)
-		db.Callback().Query().After(gormCallbackName).Register(afterName, c.afterQuery)
+		db.Callback().Query().Before(beforeAnchor).Register(beforeName, c.beforeQuery)
+		db.Callback().Query().After(afterAnchor).Register(afterName, c.afterQuery)
 	case "update":
-		db.Callback().Update().Before(gormCallbackName).Register(beforeName, c.beforeUpdate)
-		db.Callback().Update().After(gormCallbackName).Register(afterName, c.afterUpdate)
+		db.Callback().Update().Before(beforeAnchor).Register(beforeName, c.beforeUpdate)
+		db.Callback().Update().After(afterAnchor).Register(afterName, c.afterUpdate)
+		if c.opts.transactionSpans {
+			db.Callback().Update().After(gormCommitCallbackName).Register(commitName, c.afterCommit)
+		}
+		if c.opts.poolStats {
+			db.Callback().Update().Before(gormBeginCallbackName).Register(poolName, c.poolSnapshot)
+		}
 	case "delete":
-		db.Callback().Delete().Before(gormCallbackName).Register(beforeName, c.beforeDelete)
-		db.Callback().Delete().After(gormCallbackName).Register(afterName, c.afterDelete)
+		db.Callback().Delete().Before(beforeAnchor).Register(beforeName, c.beforeDelete)
+		db.Callback().Delete().After(afterAnchor).Register(afterName, c.afterDelete)
+		if c.opts.transactionSpans {
+			db.Callback().Delete().After(gormCommitCallbackName).Register(commitName, c.afterCommit)
+		}
+		if c.opts.poolStats {
+			db.Callback().Delete().Before(gormBeginCallbackName).Register(poolName, c.poolSnapshot)
+		}
 	case "row_query":
-		db.Callback().RowQuery().Before(gormCallbackName).Register(beforeName, c.beforeRowQuery)
-		db.Callback().RowQuery().After(gormCallbackName).Register(afterName, c.afterRowQuery)
+		db.Callback().RowQuery().Before(beforeAnchor).Register(beforeName, c.beforeRowQuery)
+		db.Callback().RowQuery().After(afterAnchor).Register(afterName, c.afterRowQuery)
 	}
 }
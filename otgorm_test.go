@@ -0,0 +1,472 @@
+package otgorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+type testUser struct {
+	ID   uint
+	Name string
+}
+
+// newTestDB opens an in-memory sqlite DB with the test_users table and tracing callbacks configured by opts
+func newTestDB(t *testing.T, opts ...Option) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.AutoMigrate(&testUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	AddGormCallbacksWithOptions(db, opts...)
+	return db
+}
+
+// newTestContext returns a context carrying a root span of tr
+func newTestContext(tr opentracing.Tracer) context.Context {
+	return opentracing.ContextWithSpan(context.Background(), tr.StartSpan("root"))
+}
+
+// finishedStatementSpans returns finished spans of tr other than the root one
+func finishedStatementSpans(tr *mocktracer.MockTracer) []*mocktracer.MockSpan {
+	var spans []*mocktracer.MockSpan
+	for _, sp := range tr.FinishedSpans() {
+		if sp.OperationName != "root" {
+			spans = append(spans, sp)
+		}
+	}
+	return spans
+}
+
+func TestRootSpansNoopGlobalTracer(t *testing.T) {
+	if _, ok := opentracing.GlobalTracer().(opentracing.NoopTracer); !ok {
+		t.Fatalf("global tracer is %T, want NoopTracer", opentracing.GlobalTracer())
+	}
+	db := newTestDB(t, WithRootSpans())
+	if err := db.Create(&testUser{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var user testUser
+	if err := db.First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tr := mocktracer.New()
+	opentracing.SetGlobalTracer(tr)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	if err := db.Create(&testUser{Name: "b"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tr.FinishedSpans()); n != 1 {
+		t.Fatalf("got %d spans from global tracer, want 1", n)
+	}
+}
+
+func TestNoRootSpansWithoutParent(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithTracer(tr))
+	if err := db.Create(&testUser{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tr.FinishedSpans()); n != 0 {
+		t.Fatalf("got %d spans, want none", n)
+	}
+}
+
+func TestSkipDDLFinishesSpanUnsampled(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithSkipDDL())
+	rows, err := SetSpanToGorm(newTestContext(tr), db).Raw("CREATE TABLE skipped (id integer)").Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].SpanContext.Sampled {
+		t.Fatal("span is sampled, want sampling priority 0")
+	}
+	if got := spans[0].Tag(string(ext.DBStatement)); got != nil {
+		t.Fatalf("db.statement = %v, want none", got)
+	}
+}
+
+func TestTableTag(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t)
+	if err := db.Table("archived_users").CreateTable(&testUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	ctx := newTestContext(tr)
+	tests := []struct {
+		name string
+		run  func(db *gorm.DB) error
+		want string
+	}{
+		{"Table", func(db *gorm.DB) error {
+			return db.Table("archived_users").Create(&testUser{Name: "a"}).Error
+		}, "archived_users"},
+		{"Model", func(db *gorm.DB) error {
+			return db.Model(&testUser{}).Where("name = ?", "a").Update("name", "b").Error
+		}, "test_users"},
+		{"Raw", func(db *gorm.DB) error {
+			var users []testUser
+			return db.Raw("SELECT * FROM archived_users WHERE name = ?", "a").Scan(&users).Error
+		}, "archived_users"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr.Reset()
+			if err := test.run(SetSpanToGorm(ctx, db)); err != nil {
+				t.Fatal(err)
+			}
+			spans := finishedStatementSpans(tr)
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if got := spans[0].Tag("db.table"); got != test.want {
+				t.Errorf("db.table = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMinRowsFinishesSpanUnsampled(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithMinRows(1))
+	ctx := newTestContext(tr)
+	for _, name := range []string{"a", "b"} {
+		if err := db.Create(&testUser{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var users []testUser
+	if err := SetSpanToGorm(ctx, db).Where("name = ?", "a").Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].SpanContext.Sampled {
+		t.Error("span of the 1 row query is sampled, want sampling priority 0")
+	}
+	if !spans[1].SpanContext.Sampled {
+		t.Error("span of the 2 rows query isn't sampled")
+	}
+}
+
+func TestDisableCallback(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t)
+	ctx := newTestContext(tr)
+	var users []testUser
+	DisableCallback("query")
+	defer EnableCallback("query")
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(finishedStatementSpans(tr)); n != 0 {
+		t.Fatalf("got %d spans while disabled, want none", n)
+	}
+	EnableCallback("query")
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(finishedStatementSpans(tr)); n != 1 {
+		t.Fatalf("got %d spans once enabled, want 1", n)
+	}
+}
+
+func TestDisableCallbackDuringStatement(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t)
+	db.Callback().Query().Before("gorm:query").After("tracing:query_before").Register("test:disable", func(*gorm.Scope) {
+		DisableCallback("query")
+	})
+	defer EnableCallback("query")
+	var users []testUser
+	if err := SetSpanToGorm(newTestContext(tr), db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(finishedStatementSpans(tr)); n != 1 {
+		t.Fatalf("got %d spans, want the started one finished", n)
+	}
+}
+
+func TestRowQuerySpanName(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		sql  string
+		want string
+	}{
+		{"Default", nil, "SELECT name FROM test_users", "sql SELECT"},
+		{"SpanName", []Option{WithSpanName("db")}, "SELECT name FROM test_users", "db SELECT"},
+		{"TableOperationName", []Option{WithSpanName("db"), WithTableOperationNames(map[string]string{"test_users": "users"})},
+			"SELECT name FROM test_users", "users"},
+		{"OtherVerb", nil, "PRAGMA user_version", "sql PRAGMA"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := mocktracer.New()
+			db := newTestDB(t, test.opts...)
+			rows, err := SetSpanToGorm(newTestContext(tr), db).Raw(test.sql).Rows()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows.Close()
+			spans := finishedStatementSpans(tr)
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if spans[0].OperationName != test.want {
+				t.Errorf("operation name = %q, want %q", spans[0].OperationName, test.want)
+			}
+		})
+	}
+}
+
+func TestRowQueryMethodTag(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t)
+	ctx := newTestContext(tr)
+	for _, sql := range []string{
+		"-- count users\nSELECT count(*) FROM test_users",
+		"/* report */ WITH named AS (SELECT * FROM test_users) SELECT count(*) FROM named",
+		"\n\n   SELECT count(*) FROM test_users",
+	} {
+		tr.Reset()
+		rows, err := SetSpanToGorm(ctx, db).Raw(sql).Rows()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		spans := finishedStatementSpans(tr)
+		if len(spans) != 1 {
+			t.Fatalf("%q: got %d spans, want 1", sql, len(spans))
+		}
+		if got := spans[0].Tag("db.method"); got != "SELECT" {
+			t.Errorf("%q: db.method = %v, want SELECT", sql, got)
+		}
+	}
+}
+
+func TestSkipReadsFinishesSpanUnsampled(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithSkipReads())
+	ctx := newTestContext(tr)
+	if err := SetSpanToGorm(ctx, db).Create(&testUser{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var users []testUser
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if !spans[0].SpanContext.Sampled {
+		t.Error("span of the write isn't sampled")
+	}
+	if spans[1].SpanContext.Sampled {
+		t.Error("span of the read is sampled, want sampling priority 0")
+	}
+}
+
+// nilTracerSpan is a span whose Tracer returns nil like the ones of some mock and noop setups
+type nilTracerSpan struct {
+	opentracing.Span
+}
+
+func (nilTracerSpan) Tracer() opentracing.Tracer { return nil }
+
+func TestParentSpanWithNilTracer(t *testing.T) {
+	tr := mocktracer.New()
+	parent := nilTracerSpan{tr.StartSpan("root")}
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	var users []testUser
+
+	db := newTestDB(t)
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(finishedStatementSpans(tr)); n != 0 {
+		t.Fatalf("got %d spans without a configured tracer, want none", n)
+	}
+
+	db = newTestDB(t, WithTracer(tr))
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans from the configured tracer, want 1", len(spans))
+	}
+	if want := parent.Context().(mocktracer.MockSpanContext).SpanID; spans[0].ParentID != want {
+		t.Errorf("parent id = %d, want %d", spans[0].ParentID, want)
+	}
+}
+
+func TestResetGormCallbacks(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithTransactionSpans())
+	ctx := newTestContext(tr)
+	db.InstantSet(retryGormKey, 2)
+	DisableCallback("row_query")
+	defer EnableCallback("row_query")
+	if err := ResetGormCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range callbackKinds {
+		if callbackDisabled(kind) {
+			t.Errorf("%s is still disabled", kind)
+		}
+		for _, name := range callbackNames(kind) {
+			if callbackProcessor(db, kind).Get(name) != nil {
+				t.Errorf("%s is still registered", name)
+			}
+		}
+	}
+	if err := SetSpanToGorm(ctx, db).Create(&testUser{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n := len(finishedStatementSpans(tr)); n != 0 {
+		t.Fatalf("got %d spans after reset, want none", n)
+	}
+
+	AddGormCallbacks(db)
+	rows, err := SetSpanToGorm(ctx, db).Raw("SELECT name FROM test_users").Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans once added again, want 1", len(spans))
+	}
+	if got := spans[0].Tag("db.retry.attempt"); got != nil {
+		t.Errorf("db.retry.attempt = %v, want none after reset", got)
+	}
+}
+
+func TestModelTableWinsOverSQL(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t)
+	if err := db.Table("archived_users").CreateTable(&testUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var users []testUser
+	err := SetSpanToGorm(newTestContext(tr), db).Select("(SELECT count(*) FROM archived_users) AS id, name").Find(&users).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := spans[0].Tag("db.table"); got != "test_users" {
+		t.Errorf("db.table = %v, want test_users", got)
+	}
+}
+
+type rejectedUser struct {
+	ID   uint
+	Name string
+}
+
+func (rejectedUser) BeforeCreate() error { return errors.New("rejected") }
+
+func TestEmptySQLFinishesSpan(t *testing.T) {
+	tr := mocktracer.New()
+	var metrics []QueryMetrics
+	db := newTestDB(t, WithDefaultOperation("UNKNOWN"), WithMetricsHook(func(m QueryMetrics) {
+		metrics = append(metrics, m)
+	}))
+	if err := SetSpanToGorm(newTestContext(tr), db).Create(&rejectedUser{Name: "a"}).Error; err == nil {
+		t.Fatal("create succeeded despite the BeforeCreate hook")
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := spans[0].Tag("db.method"); got != "UNKNOWN" {
+		t.Errorf("db.method = %v, want UNKNOWN", got)
+	}
+	if got := spans[0].Tag(string(ext.Error)); got != true {
+		t.Errorf("error = %v, want true", got)
+	}
+	if got := spans[0].Tag(string(ext.DBStatement)); got != nil {
+		t.Errorf("db.statement = %v, want none", got)
+	}
+	if len(metrics) != 1 || metrics[0].Operation != "UNKNOWN" || metrics[0].Table != "rejected_users" {
+		t.Errorf("metrics = %+v, want one UNKNOWN rejected_users entry", metrics)
+	}
+}
+
+func TestPoolStatsTagsWrites(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithPoolStats())
+	ctx := newTestContext(tr)
+	user := testUser{Name: "a"}
+	if err := SetSpanToGorm(ctx, db).Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSpanToGorm(ctx, db).Model(&user).Update("name", "b").Error; err != nil {
+		t.Fatal(err)
+	}
+	var users []testUser
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSpanToGorm(ctx, db).Delete(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+	for _, sp := range spans {
+		if _, ok := sp.Tag("db.pool.wait_ms").(float64); !ok {
+			t.Errorf("%v: db.pool.wait_ms = %v, want a value", sp.Tag("db.method"), sp.Tag("db.pool.wait_ms"))
+		}
+	}
+}
+
+func TestCommonTagsRespectMaxTagValueLength(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithMaxTagValueLength(4), WithComponent("gorm-orders"), WithDBType("postgresql"))
+	var users []testUser
+	if err := SetSpanToGorm(newTestContext(tr), db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := spans[0].Tag(string(ext.Component)); got != "gorm" {
+		t.Errorf("component = %v, want gorm", got)
+	}
+	if got := spans[0].Tag(string(ext.DBType)); got != "post" {
+		t.Errorf("db.type = %v, want post", got)
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/echo-health/opentracing-gorm/otgormprom
+
+go 1.14
+
+require (
+	github.com/echo-health/opentracing-gorm v0.0.0-20261014043653-fe9f8f63da26
+	github.com/prometheus/client_golang v1.11.1
+)
+
+// local development only, replace is ignored when otgormprom is a dependency
+// and the version required above is used instead
+replace github.com/echo-health/opentracing-gorm => ../
@@ -0,0 +1,124 @@
+// Package otgormprom exposes query metrics reported by otgorm callbacks as a prometheus collector
+package otgormprom
+
+import (
+	"sync"
+
+	otgorm "github.com/echo-health/opentracing-gorm"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const otherTable = "other"
+
+// Collector counts queries by operation, table and result and observes their durations
+type Collector struct {
+	queries   *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	maxTables int
+
+	mu     sync.Mutex
+	tables map[string]struct{}
+}
+
+// Option configures Collector
+type Option func(*config)
+
+type config struct {
+	namespace string
+	buckets   []float64
+	maxTables int
+}
+
+// WithNamespace sets namespace of the metrics
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets buckets of the duration histogram, in seconds
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// WithMaxTables limits the number of distinct table label values, tables seen after the limit is reached
+// are reported as "other". Defaults to 100, zero or negative disables the limit
+func WithMaxTables(n int) Option {
+	return func(c *config) {
+		c.maxTables = n
+	}
+}
+
+// NewCollector returns collector, register it with prometheus and pass its Observe method to otgorm.WithMetricsHook:
+//
+//	collector := otgormprom.NewCollector()
+//	prometheus.MustRegister(collector)
+//	otgorm.AddGormCallbacksWithOptions(db, otgorm.WithMetricsHook(collector.Observe))
+func NewCollector(opts ...Option) *Collector {
+	cfg := &config{
+		buckets:   prometheus.DefBuckets,
+		maxTables: 100,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &Collector{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Subsystem: "gorm",
+			Name:      "queries_total",
+			Help:      "Number of executed statements.",
+		}, []string{"operation", "table", "result"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Subsystem: "gorm",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of executed statements.",
+			Buckets:   cfg.buckets,
+		}, []string{"operation", "table"}),
+		maxTables: cfg.maxTables,
+		tables:    map[string]struct{}{},
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.queries.Describe(ch)
+	c.durations.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.queries.Collect(ch)
+	c.durations.Collect(ch)
+}
+
+// Observe records the statement
+func (c *Collector) Observe(m otgorm.QueryMetrics) {
+	table := c.table(m.Table)
+	result := "ok"
+	if m.Err != nil {
+		result = "error"
+	}
+	c.queries.WithLabelValues(m.Operation, table, result).Inc()
+	c.durations.WithLabelValues(m.Operation, table).Observe(m.Duration.Seconds())
+}
+
+// table returns label value for table, keeping number of distinct values within the limit
+func (c *Collector) table(table string) string {
+	if c.maxTables <= 0 {
+		return table
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.tables[table]; ok {
+		return table
+	}
+	if len(c.tables) >= c.maxTables {
+		return otherTable
+	}
+	c.tables[table] = struct{}{}
+	return table
+}
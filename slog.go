@@ -0,0 +1,56 @@
+//go:build go1.21
+// +build go1.21
+
+package otgorm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+// WithSlog logs a record per statement with its operation, table, duration, error and the trace id of its span
+// at level, slog.Default() is used when logger is nil
+func WithSlog(logger *slog.Logger, level slog.Level) Option {
+	return func(o *options) {
+		o.logRecord = func(sp opentracing.Span, m QueryMetrics) {
+			l := logger
+			if l == nil {
+				l = slog.Default()
+			}
+			attrs := []slog.Attr{
+				slog.String("operation", m.Operation),
+				slog.String("table", m.Table),
+				slog.Duration("duration", m.Duration),
+				slog.Int64("rows_affected", m.RowsAffected),
+			}
+			if m.Err != nil {
+				attrs = append(attrs, slog.String("error", m.Err.Error()))
+			}
+			if id := traceID(sp); id != "" {
+				attrs = append(attrs, slog.String("trace_id", id))
+			}
+			l.LogAttrs(context.Background(), level, "gorm query", attrs...)
+		}
+	}
+}
+
+// Slog is the builder form of WithSlog
+func (b *Options) Slog(logger *slog.Logger, level slog.Level) *Options {
+	return b.add(WithSlog(logger, level))
+}
+
+// traceID returns trace id of sp when its context has a TraceID method, like the jaeger and datadog ones
+func traceID(sp opentracing.Span) string {
+	if sp == nil {
+		return ""
+	}
+	method := reflect.ValueOf(sp.Context()).MethodByName("TraceID")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return ""
+	}
+	return fmt.Sprint(method.Call(nil)[0].Interface())
+}
@@ -0,0 +1,430 @@
+package otgorm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// insertSelectSource reports whether the first statement of sql is an INSERT ... SELECT and returns
+// the table the SELECT reads from
+func insertSelectSource(sql string) (string, bool) {
+	stmts := splitStatements(sql)
+	if len(stmts) == 0 {
+		return "", false
+	}
+	sql = stmts[0]
+	inserting, found := false, false
+	source := ""
+	scanWords(sql, func(word string, pos, depth int) bool {
+		switch {
+		case strings.EqualFold(word, "INSERT"):
+			inserting = true
+		case inserting && depth == 0 && strings.EqualFold(word, "SELECT"):
+			found = true
+			source = sqlTable(sql[pos:])
+			return false
+		}
+		return true
+	})
+	return source, found
+}
+
+// dbSystems maps gorm dialect names to OpenTelemetry db.system values
+var dbSystems = map[string]string{
+	"postgres": "postgresql",
+	"sqlite3":  "sqlite",
+}
+
+// dbSystem returns OpenTelemetry db.system value of dialect
+func dbSystem(dialect string) string {
+	if system, ok := dbSystems[dialect]; ok {
+		return system
+	}
+	return dialect
+}
+
+// knownVerbs are the verbs reported as operation when WithDefaultOperation is set
+var knownVerbs = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "REPLACE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+	"BEGIN": true, "START": true, "COMMIT": true, "ROLLBACK": true, "SAVEPOINT": true, "RELEASE": true,
+	"EXPLAIN": true, "SHOW": true, "DESCRIBE": true, "SET": true, "USE": true, "CALL": true, "EXEC": true, "EXECUTE": true,
+	"GRANT": true, "REVOKE": true, "LOCK": true, "UNLOCK": true, "COPY": true, "VACUUM": true, "ANALYZE": true, "PRAGMA": true,
+}
+
+// sqlVerb returns upper cased first word of sql skipping comments and parentheses,
+// for WITH queries the verb of the main statement is returned
+func sqlVerb(sql string) string {
+	// MySQL # comments are only skipped before the first word, later # may be a Postgres operator
+	for sql = strings.TrimSpace(sql); strings.HasPrefix(sql, "#"); sql = strings.TrimSpace(sql) {
+		sql = sql[skipLineComment(sql, 0):]
+	}
+	verb := ""
+	cte := false
+	scanWords(sql, func(word string, _, depth int) bool {
+		word = strings.ToUpper(word)
+		if !cte {
+			if word == "WITH" {
+				cte = true
+				return true
+			}
+			verb = word
+			return false
+		}
+		switch word {
+		case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE":
+			if depth == 0 {
+				verb = word
+				return false
+			}
+		}
+		return true
+	})
+	return verb
+}
+
+func isDDL(sql string) bool {
+	switch sqlVerb(sql) {
+	case "CREATE", "ALTER", "DROP":
+		return true
+	}
+	return false
+}
+
+// tableName returns table the statement works with, the one of the model or set with Table unless the
+// statement is raw SQL, which is parsed as scanning it into a model doesn't make it query the model's table
+func tableName(scope *gorm.Scope) string {
+	if !rawQuery(scope) {
+		if table := scope.TableName(); table != "" {
+			return table
+		}
+	}
+	if table := sqlTable(scope.SQL); table != "" {
+		return table
+	}
+	return scope.TableName()
+}
+
+// rawQuery reports whether the scope runs SQL given to Raw, gorm keeps the flag unexported
+func rawQuery(scope *gorm.Scope) bool {
+	if scope.Search == nil {
+		return false
+	}
+	v := reflect.ValueOf(scope.Search).Elem().FieldByName("raw")
+	return v.IsValid() && v.Kind() == reflect.Bool && v.Bool()
+}
+
+// returnedRows returns number of rows a query scanned into its destination, the slice length
+// for slices and rows affected otherwise
+func returnedRows(scope *gorm.Scope) int64 {
+	if scope.Value != nil {
+		if v := scope.IndirectValue(); v.Kind() == reflect.Slice {
+			return int64(v.Len())
+		}
+	}
+	return scope.DB().RowsAffected
+}
+
+// sqlTable returns the unquoted table following the first FROM, INTO or UPDATE keyword of sql outside of
+// parentheses, comments and quoted strings, so EXTRACT(... FROM ...) and subqueries are skipped
+func sqlTable(sql string) string {
+	table := ""
+	scanWords(sql, func(word string, pos, depth int) bool {
+		if depth != 0 {
+			return true
+		}
+		switch strings.ToUpper(word) {
+		case "FROM", "INTO", "UPDATE":
+			table = tableAt(sql, pos+len(word))
+		}
+		return table == ""
+	})
+	return table
+}
+
+// tableAt returns the unquoted table name starting at i after whitespace and comments,
+// empty if a subquery or anything else follows
+func tableAt(sql string, i int) string {
+	for i < len(sql) {
+		switch {
+		case sql[i] == ' ' || sql[i] == '\t' || sql[i] == '\n' || sql[i] == '\r':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		default:
+			start := i
+			for i < len(sql) {
+				if ch := sql[i]; ch == '"' || ch == '`' {
+					i = skipQuoted(sql, i)
+				} else if isWordChar(ch) || ch == '.' || ch == '[' || ch == ']' {
+					i++
+				} else {
+					break
+				}
+			}
+			return unquoteTable(sql[start:i])
+		}
+	}
+	return ""
+}
+
+// unquoteTable returns table name of token cut at the first parenthesis, comma or semicolon, without quotes
+func unquoteTable(token string) string {
+	if idx := strings.IndexAny(token, "(),;"); idx >= 0 {
+		token = token[:idx]
+	}
+	return strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(token)
+}
+
+// maxJoinsTagged limits number of tables in the db.joins tag
+const maxJoinsTagged = 10
+
+// sqlJoins returns tables joined by sql, joined subqueries are skipped
+func sqlJoins(sql string) []string {
+	var joins []string
+	scanWords(sql, func(word string, pos, _ int) bool {
+		if !strings.EqualFold(word, "JOIN") {
+			return true
+		}
+		if fields := strings.Fields(sql[pos+len(word):]); len(fields) > 0 {
+			if table := unquoteTable(fields[0]); table != "" {
+				joins = append(joins, table)
+			}
+		}
+		return true
+	})
+	return joins
+}
+
+// joinCount returns number of JOIN keywords of sql outside of parentheses, joined subqueries included
+func joinCount(sql string) int {
+	n := 0
+	scanWords(sql, func(word string, _, depth int) bool {
+		if depth == 0 && strings.EqualFold(word, "JOIN") {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// splitStatements splits sql into its non empty statements separated by semicolons
+// outside of comments and quoted strings
+func splitStatements(sql string) []string {
+	var stmts []string
+	start := 0
+	add := func(end int) {
+		if stmt := strings.TrimSpace(sql[start:end]); stmt != "" && sqlVerb(stmt) != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	for i := 0; i < len(sql); {
+		switch ch := sql[i]; {
+		case strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuoted(sql, i)
+		case ch == ';':
+			add(i)
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	add(len(sql))
+	return stmts
+}
+
+// maskLiterals replaces quoted strings and numbers of sql with ?, keywords, identifiers,
+// comments and placeholders like $1 are kept
+func maskLiterals(sql string) string {
+	var b strings.Builder
+	for i := 0; i < len(sql); {
+		j := i + 1
+		switch ch := sql[i]; {
+		case strings.HasPrefix(sql[i:], "--"):
+			j = skipLineComment(sql, i)
+		case strings.HasPrefix(sql[i:], "/*"):
+			j = skipBlockComment(sql, i)
+		case ch == '"' || ch == '`':
+			j = skipQuoted(sql, i)
+		case ch == '\'':
+			i = skipQuoted(sql, i)
+			b.WriteByte('?')
+			continue
+		case isWordChar(ch):
+			numeric := isDigit(ch) && (i == 0 || !strings.ContainsRune("$@:", rune(sql[i-1])))
+			for j < len(sql) && (isWordChar(sql[j]) || numeric && sql[j] == '.') {
+				j++
+			}
+			if numeric {
+				i = j
+				b.WriteByte('?')
+				continue
+			}
+		}
+		b.WriteString(sql[i:j])
+		i = j
+	}
+	return b.String()
+}
+
+// redactWhere masks literals following the first WHERE of sql with maskLiterals, columns and everything
+// before WHERE are kept
+func redactWhere(sql string) string {
+	where := -1
+	scanWords(sql, func(word string, pos, _ int) bool {
+		if strings.EqualFold(word, "WHERE") {
+			where = pos
+			return false
+		}
+		return true
+	})
+	if where < 0 {
+		return sql
+	}
+	return sql[:where] + maskLiterals(sql[where:])
+}
+
+// fingerprint returns short hash of sql of dialect with masked literals, placeholders replaced with ?
+// and collapsed whitespace, statements differing only in inlined values share it
+func fingerprint(sql, dialect string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(strings.Fields(normalizePlaceholders(maskLiterals(sql), dialect)), " ")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// placeholders returns start and end positions of bind variable placeholders of sql outside of comments and
+// quoted strings. Postgres uses $1, SQL Server @p1 or ?, other dialects ?
+func placeholders(sql, dialect string) [][2]int {
+	var positions [][2]int
+	for i := 0; i < len(sql); {
+		switch ch := sql[i]; {
+		case strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuoted(sql, i)
+		case ch == '?' && dialect != "postgres":
+			positions = append(positions, [2]int{i, i + 1})
+			i++
+		case ch == '$' && dialect == "postgres", ch == '@' && dialect == "mssql":
+			j := i + 1
+			if ch == '@' && j < len(sql) && (sql[j] == 'p' || sql[j] == 'P') {
+				j++
+			}
+			digits := j
+			for j < len(sql) && isDigit(sql[j]) {
+				j++
+			}
+			if j > digits {
+				positions = append(positions, [2]int{i, j})
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	return positions
+}
+
+// normalizePlaceholders replaces placeholders of sql listed by placeholders with ?
+func normalizePlaceholders(sql, dialect string) string {
+	var b strings.Builder
+	last := 0
+	for _, pos := range placeholders(sql, dialect) {
+		b.WriteString(sql[last:pos[0]])
+		b.WriteByte('?')
+		last = pos[1]
+	}
+	b.WriteString(sql[last:])
+	return b.String()
+}
+
+// scanWords calls fn for every word of sql outside of comments and quoted strings with its position and
+// the parentheses depth it appears at, scanning stops once fn returns false
+func scanWords(sql string, fn func(word string, pos, depth int) bool) {
+	depth := 0
+	for i := 0; i < len(sql); {
+		switch ch := sql[i]; {
+		case strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuoted(sql, i)
+		case ch == '(':
+			depth++
+			i++
+		case ch == ')':
+			depth--
+			i++
+		case isWordChar(ch):
+			j := i
+			for j < len(sql) && isWordChar(sql[j]) {
+				j++
+			}
+			if !fn(sql[i:j], i, depth) {
+				return
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+// skipLineComment returns index right after the line comment starting at i
+func skipLineComment(sql string, i int) int {
+	if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(sql)
+}
+
+// skipBlockComment returns index right after the block comment starting at i
+func skipBlockComment(sql string, i int) int {
+	if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(sql)
+}
+
+// skipQuoted returns index right after the quoted string or identifier starting at i,
+// doubled quotes and backslash escaped single quotes are kept inside
+func skipQuoted(sql string, i int) int {
+	quote := sql[i]
+	for j := i + 1; j < len(sql); j++ {
+		switch sql[j] {
+		case '\\':
+			if quote == '\'' {
+				j++
+			}
+		case quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isWordChar(ch byte) bool {
+	return ch == '_' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+}
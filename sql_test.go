@@ -0,0 +1,174 @@
+package otgorm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSQLTable(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{`SELECT * FROM "users" WHERE id = ?`, "users"},
+		{"SELECT * FROM `shop`.`orders`", "shop.orders"},
+		{`INSERT INTO "users" ("name") VALUES (?)`, "users"},
+		{`UPDATE "users" SET "name" = ?`, "users"},
+		{`DELETE FROM users WHERE id = ?`, "users"},
+		{`SELECT 1`, ""},
+		{`/* read from cache */ SELECT * FROM users`, "users"},
+		{"-- copy from backup\nSELECT * FROM users", "users"},
+		{"SELECT * FROM -- main table\n users", "users"},
+		{`SELECT * FROM users WHERE name = 'from x'`, "users"},
+		{`SELECT EXTRACT(YEAR FROM created_at) FROM "users"`, "users"},
+		{`SELECT TRIM(LEADING 'x' FROM name) FROM "users"`, "users"},
+		{`SELECT (SELECT count(*) FROM orders) AS n, name FROM "users"`, "users"},
+		{`SELECT * FROM (SELECT * FROM users) AS u`, ""},
+		{`INSERT INTO users(name) VALUES (?)`, "users"},
+		{`SELECT * FROM "my users"`, "my users"},
+	}
+	for _, test := range tests {
+		if got := sqlTable(test.sql); got != test.want {
+			t.Errorf("sqlTable(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestSQLVerb(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{`SELECT * FROM users`, "SELECT"},
+		{`select * from users`, "SELECT"},
+		{`-- list users
+SELECT * FROM users`, "SELECT"},
+		{`/* app:api */ UPDATE users SET name = ?`, "UPDATE"},
+		{`# mysql comment
+DELETE FROM users`, "DELETE"},
+		{`WITH recent AS (SELECT * FROM users) SELECT * FROM recent`, "SELECT"},
+		{`WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t) SELECT n FROM t`, "SELECT"},
+		{`WITH moved AS (DELETE FROM users RETURNING *) INSERT INTO archived_users SELECT * FROM moved`, "INSERT"},
+		{`(SELECT id FROM users) UNION (SELECT id FROM archived_users)`, "SELECT"},
+		{`SELECT * FROM (SELECT * FROM users) AS u WHERE u.id IN (SELECT id FROM admins)`, "SELECT"},
+		{``, ""},
+	}
+	for _, test := range tests {
+		if got := sqlVerb(test.sql); got != test.want {
+			t.Errorf("sqlVerb(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestSQLVerbRowQueries(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"\n\t  SELECT 1", "SELECT"},
+		{"\r\n\r\nselect 1\n", "SELECT"},
+		{"-- first\n-- second\n  SELECT 1", "SELECT"},
+		{"/* multi\nline */\n/* another */ SELECT 1", "SELECT"},
+		{"  # a\n  # b\nSELECT 1", "SELECT"},
+		{"/* unterminated", ""},
+		{"-- only a comment", ""},
+		{"\n  WITH t AS (\n  SELECT 1 AS n\n)\n-- main\nSELECT n FROM t", "SELECT"},
+		{"with a AS (SELECT 1), b AS (SELECT 2) select * from a, b", "SELECT"},
+		{"/* head */ WITH t AS (SELECT 1) UPDATE users SET name = 'WITH'", "UPDATE"},
+	}
+	for _, test := range tests {
+		if got := sqlVerb(test.sql); got != test.want {
+			t.Errorf("sqlVerb(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestMaskLiterals(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{`SELECT * FROM users WHERE name = 'it''s'`, `SELECT * FROM users WHERE name = ?`},
+		{`SELECT * FROM users WHERE name = 'a\'b' AND id = 1`, `SELECT * FROM users WHERE name = ? AND id = ?`},
+		{`SELECT * FROM items WHERE price > 1.5e3 OR price < 0.25`, `SELECT * FROM items WHERE price > ? OR price < ?`},
+		{`SELECT col2, t1.col3 FROM t1 WHERE col2 = 42`, `SELECT col2, t1.col3 FROM t1 WHERE col2 = ?`},
+		{`SELECT * FROM users WHERE id = $1 AND name = $2`, `SELECT * FROM users WHERE id = $1 AND name = $2`},
+		{`SELECT * FROM users WHERE id = @p1`, `SELECT * FROM users WHERE id = @p1`},
+		{`SELECT * FROM users WHERE id = :id`, `SELECT * FROM users WHERE id = :id`},
+		{"SELECT 1 -- 'x' 42\nFROM users", "SELECT ? -- 'x' 42\nFROM users"},
+		{`SELECT /* 7 'y' */ "weird 'name'" FROM users`, `SELECT /* 7 'y' */ "weird 'name'" FROM users`},
+	}
+	for _, test := range tests {
+		if got := maskLiterals(test.sql); got != test.want {
+			t.Errorf("maskLiterals(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestRedactWhere(t *testing.T) {
+	sql := `SELECT 'x', 1 FROM "where" WHERE name = 'it''s' AND id = 3`
+	want := `SELECT 'x', 1 FROM "where" WHERE name = ? AND id = ?`
+	if got := redactWhere(sql); got != want {
+		t.Errorf("redactWhere(%q) = %q, want %q", sql, got, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := fingerprint("SELECT * FROM users WHERE id = $1 AND name = 'a'", "postgres")
+	b := fingerprint("SELECT *  FROM users\n WHERE id = $2 AND name = 'it''s'", "postgres")
+	if a != b {
+		t.Errorf("fingerprints differ: %s, %s", a, b)
+	}
+	if c := fingerprint("SELECT * FROM orders WHERE id = $1", "postgres"); c == a {
+		t.Errorf("fingerprint of another table is %s too", c)
+	}
+}
+
+func TestJoins(t *testing.T) {
+	tests := []struct {
+		sql   string
+		joins []string
+		count int
+	}{
+		{`SELECT * FROM users`, nil, 0},
+		{`SELECT * FROM users JOIN orders ON orders.user_id = users.id LEFT JOIN "items" ON items.order_id = orders.id`,
+			[]string{"orders", "items"}, 2},
+		{`SELECT * FROM users LEFT JOIN (SELECT user_id, count(*) FROM orders GROUP BY user_id) o ON o.user_id = users.id`,
+			nil, 1},
+		{`SELECT * FROM users u JOIN (SELECT * FROM orders JOIN items ON items.order_id = orders.id) o ON o.user_id = u.id`,
+			[]string{"items"}, 1},
+		{`SELECT 'a JOIN b' FROM users -- JOIN x`, nil, 0},
+	}
+	for _, test := range tests {
+		joins := sqlJoins(test.sql)
+		if strings.Join(joins, ",") != strings.Join(test.joins, ",") {
+			t.Errorf("sqlJoins(%q) = %v, want %v", test.sql, joins, test.joins)
+		}
+		if got := joinCount(test.sql); got != test.count {
+			t.Errorf("joinCount(%q) = %d, want %d", test.sql, got, test.count)
+		}
+	}
+}
+
+func TestInsertSelectSource(t *testing.T) {
+	tests := []struct {
+		sql    string
+		source string
+		ok     bool
+	}{
+		{`INSERT INTO archive SELECT * FROM users WHERE id < 10`, "users", true},
+		{`INSERT INTO archive (id, name) SELECT id, name FROM "users"`, "users", true},
+		{`INSERT INTO a (x) VALUES (1)`, "", false},
+		{`INSERT INTO a (x) VALUES (1); SELECT * FROM c`, "", false},
+		{`INSERT INTO a (x) VALUES ((SELECT max(x) FROM b))`, "", false},
+		{`-- backfill; then select
+INSERT INTO archive SELECT * FROM users; DELETE FROM users`, "users", true},
+		{`SELECT * FROM users`, "", false},
+	}
+	for _, test := range tests {
+		source, ok := insertSelectSource(test.sql)
+		if source != test.source || ok != test.ok {
+			t.Errorf("insertSelectSource(%q) = %q, %v, want %q, %v", test.sql, source, ok, test.source, test.ok)
+		}
+	}
+}
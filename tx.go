@@ -0,0 +1,155 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	gormBeginCallbackName  = "gorm:begin_transaction"
+	gormCommitCallbackName = "gorm:commit_or_rollback_transaction"
+	txOptionsGormKey       = "opentracingTxOptions"
+)
+
+// BeginTx begins a transaction like db.BeginTx and sets span from ctx like SetSpanToGorm,
+// opts are kept so statements inside the transaction are tagged with them. With WithTransactionSpans
+// the statements are grouped under a transaction span started by the first of them, finish the transaction
+// with CommitTx or RollbackTx to finish the span with the db.statement.count tag
+func BeginTx(ctx context.Context, db *gorm.DB, opts *sql.TxOptions) *gorm.DB {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx := db.BeginTx(ctx, opts).Set(userTxGormKey, &userTx{})
+	if opts != nil {
+		tx = tx.Set(txOptionsGormKey, opts)
+	}
+	return SetSpanToGorm(ctx, tx)
+}
+
+// CommitTx commits transaction begun with BeginTx and finishes its transaction span
+func CommitTx(tx *gorm.DB) *gorm.DB {
+	tx = tx.Commit()
+	finishUserTransaction(tx, false)
+	return tx
+}
+
+// RollbackTx rolls back transaction begun with BeginTx and finishes its transaction span tagged with db.rollback
+func RollbackTx(tx *gorm.DB) *gorm.DB {
+	tx = tx.Rollback()
+	finishUserTransaction(tx, true)
+	return tx
+}
+
+// userTx is the state of a transaction begun with BeginTx shared by its statements
+type userTx struct {
+	mu       sync.Mutex
+	c        *callbacks
+	span     opentracing.Span
+	finished bool
+	count    int64
+}
+
+// start returns transaction span, starting it with tr and opts if the transaction has none yet
+func (t *userTx) start(c *callbacks, tr opentracing.Tracer, opts []opentracing.StartSpanOption) opentracing.Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.span == nil {
+		t.c = c
+		t.span = tr.StartSpan(DefaultTransactionSpanName, opts...)
+		c.setCommonTags(t.span)
+	}
+	return t.span
+}
+
+// userTransaction returns state of the transaction begun with BeginTx the scope runs in
+func userTransaction(scope *gorm.Scope) (*userTx, bool) {
+	val, ok := gormValue(scope, userTxGormKey)
+	if !ok || !inTransaction(scope) {
+		return nil, false
+	}
+	return val.(*userTx), true
+}
+
+// finishUserTransaction finishes transaction span of tx once, if one of its statements started it
+func finishUserTransaction(tx *gorm.DB, rollback bool) {
+	val, ok := tx.Get(userTxGormKey)
+	if !ok || val == nil {
+		return
+	}
+	t := val.(*userTx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.span == nil || t.finished {
+		return
+	}
+	t.finished = true
+	ext.Error.Set(t.span, tx.Error != nil && t.c.opts.errorFilter(tx.Error))
+	if rollback {
+		t.c.setTag(t.span, "db.rollback", true)
+	}
+	t.c.setTag(t.span, "db.statement.count", atomic.LoadInt64(&t.count))
+	t.span.Finish()
+}
+
+// startedTransaction reports whether gorm started a transaction for the scope,
+// it is not the case when the statement runs inside a transaction already or gorm:begin_transaction is removed
+func startedTransaction(scope *gorm.Scope) bool {
+	_, ok := scope.InstanceGet("gorm:started_transaction")
+	return ok
+}
+
+// inTransaction reports whether the scope's statements run inside a transaction
+func inTransaction(scope *gorm.Scope) bool {
+	_, ok := scope.SQLDB().(*sql.Tx)
+	return ok
+}
+
+// txOptions returns options of the transaction begun with BeginTx the scope runs in
+func txOptions(scope *gorm.Scope) (*sql.TxOptions, bool) {
+	if !inTransaction(scope) {
+		return nil, false
+	}
+	val, ok := gormValue(scope, txOptionsGormKey)
+	if !ok {
+		return nil, false
+	}
+	return val.(*sql.TxOptions), true
+}
+
+// isolationLevel returns isolation level of the transaction begun with BeginTx the scope runs in,
+// empty if it runs outside of one or the level is default
+func isolationLevel(scope *gorm.Scope) string {
+	if opts, ok := txOptions(scope); ok && opts.Isolation != sql.LevelDefault {
+		return opts.Isolation.String()
+	}
+	return ""
+}
+
+// readOnly reports whether the scope runs in a read-only transaction begun with BeginTx
+func readOnly(scope *gorm.Scope) bool {
+	opts, ok := txOptions(scope)
+	return ok && opts.ReadOnly
+}
+
+// afterCommit finishes transaction span of the scope once gorm committed or rolled back its transaction
+func (c *callbacks) afterCommit(scope *gorm.Scope) {
+	if !startedTransaction(scope) {
+		return
+	}
+	val, ok := gormValue(scope, txSpanGormKey)
+	if !ok {
+		return
+	}
+	sp := val.(opentracing.Span)
+	ext.Error.Set(sp, scope.HasError() && c.opts.errorFilter(scope.DB().Error))
+	if count, ok := gormValue(scope, txCountGormKey); ok {
+		c.setTag(sp, "db.statement.count", atomic.LoadInt64(count.(*int64)))
+	}
+	sp.Finish()
+}
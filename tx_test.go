@@ -0,0 +1,102 @@
+package otgorm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestTransactionSpanErrorFilter(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t, WithTransactionSpans())
+	db.Callback().Create().After("gorm:create").Before("tracing:create_after").Register("test:fail", func(scope *gorm.Scope) {
+		scope.Err(context.Canceled)
+	})
+	SetSpanToGorm(newTestContext(tr), db).Create(&testUser{Name: "a"})
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	for _, sp := range spans {
+		if got := sp.Tag(string(ext.Error)); got != false {
+			t.Errorf("%s: error = %v, want false", sp.OperationName, got)
+		}
+	}
+}
+
+func TestInTransactionTag(t *testing.T) {
+	tr := mocktracer.New()
+	db := newTestDB(t)
+	ctx := newTestContext(tr)
+	var users []testUser
+	if err := SetSpanToGorm(ctx, db).Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSpanToGorm(ctx, db).Create(&testUser{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := SetSpanToGorm(ctx, tx).Find(&users).Error; err != nil {
+			return err
+		}
+		return SetSpanToGorm(ctx, tx).Create(&testUser{Name: "b"}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := finishedStatementSpans(tr)
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+	for i, want := range []bool{false, false, true, true} {
+		if got := spans[i].Tag("db.in_transaction"); got != want {
+			t.Errorf("%v span %d: db.in_transaction = %v, want %v", spans[i].Tag("db.method"), i, got, want)
+		}
+	}
+}
+
+func TestBeginTxTransactionSpan(t *testing.T) {
+	for _, rollback := range []bool{false, true} {
+		tr := mocktracer.New()
+		db := newTestDB(t, WithTransactionSpans())
+		tx := BeginTx(newTestContext(tr), db, nil)
+		for _, name := range []string{"a", "b"} {
+			if err := tx.Create(&testUser{Name: name}).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		var users []testUser
+		if err := tx.Find(&users).Error; err != nil {
+			t.Fatal(err)
+		}
+		finish := CommitTx
+		if rollback {
+			finish = RollbackTx
+		}
+		if err := finish(tx).Error; err != nil {
+			t.Fatal(err)
+		}
+		spans := finishedStatementSpans(tr)
+		if len(spans) != 4 {
+			t.Fatalf("got %d spans, want 4", len(spans))
+		}
+		txSpan := spans[3]
+		if txSpan.OperationName != DefaultTransactionSpanName {
+			t.Fatalf("last span is %q, want the transaction span", txSpan.OperationName)
+		}
+		if got := txSpan.Tag("db.statement.count"); got != int64(3) {
+			t.Errorf("db.statement.count = %v, want 3", got)
+		}
+		if got := txSpan.Tag("db.rollback"); rollback && got != true || !rollback && got != nil {
+			t.Errorf("db.rollback = %v with rollback %v", got, rollback)
+		}
+		for _, sp := range spans[:3] {
+			if sp.ParentID != txSpan.SpanContext.SpanID {
+				t.Errorf("%v span isn't a child of the transaction span", sp.Tag("db.method"))
+			}
+		}
+	}
+}